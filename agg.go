@@ -1,23 +1,68 @@
 package Agg
 
 import (
-	"math/big"
 	"net"
 	"net/netip"
-	"sort"
+	"slices"
 )
 
 type cidr struct {
-	netIP       netip.Addr
-	startIP     *big.Int
-	nextStartIP *big.Int // this is end IP + 1
-	ones        int
-	bits        int
+	netIP   netip.Addr
+	startIP uint128
+	endIP   uint128 // last IP in the cidr, inclusive
+	ones    int
+	bits    int
+
+	// shortest prefix length the cidr may be merged into, set when it is held
+	// aside inside a cidr that declined it, so it never reaches that network
+	minOnes int
 
 	prev *cidr
 	next *cidr
 
-	entry CidrEntry
+	// index of the entry in the input slice
+	idx int
+}
+
+// mergeHook is how the algorithm reports a merge back to the caller, it runs
+// before the network of keep is changed and returns false to decline it
+type mergeHook func(keep, delete *cidr, info MergeInfo) bool
+
+// traceHook is how the algorithm reports its steps, other is the cidr merged
+// into c, held inside c or the one the walk moves back from, result is the
+// network c has after a merge. Other is nil for a rejoin
+type traceHook func(kind TraceKind, c, other *cidr, result netip.Prefix, declined bool)
+
+// aggregator holds what the algorithm needs besides the list itself
+type aggregator struct {
+	mergeFn mergeHook
+	trace   traceHook
+
+	// step, when set, is called every checkEvery items of a phase and at its
+	// end, returning false stops the run
+	step    func(phase Phase, processed int) bool
+	stopped bool
+
+	// shortest prefix length aggregateAdj may produce, per family
+	minOnesV4 int
+	minOnesV6 int
+}
+
+// tick reports the items of a phase processed so far, and tells whether to go
+// on
+func (a *aggregator) tick(phase Phase, processed int) bool {
+	if a.step == nil || a.step(phase, processed) {
+		return true
+	}
+	a.stopped = true
+	return false
+}
+
+func (a *aggregator) minOnes(bits int) int {
+	if bits == net.IPv4len*8 {
+		return a.minOnesV4
+	}
+	return a.minOnesV6
 }
 
 type CidrEntry interface {
@@ -45,7 +90,58 @@ func NewBasicCidrEntry(ipNet netip.Prefix) CidrEntry {
 
 type Merge func(keep, delete CidrEntry)
 
+// MergeKind tells why two entries are merged
+type MergeKind int
+
+const (
+	// MergeCovered means the network of keep covers the smaller delete
+	MergeCovered MergeKind = iota
+	// MergeDuplicate means keep and delete have the same network
+	MergeDuplicate
+	// MergeAdjacent means keep and delete are siblings combined into their
+	// parent network
+	MergeAdjacent
+)
+
+func (k MergeKind) String() string {
+	switch k {
+	case MergeCovered:
+		return "covered"
+	case MergeDuplicate:
+		return "duplicate"
+	case MergeAdjacent:
+		return "adjacent"
+	}
+	return "unknown"
+}
+
+// MergeInfo describes a single merge
+type MergeInfo struct {
+	Kind MergeKind
+	// Result is the network keep ends up with after the merge
+	Result netip.Prefix
+	// Keep and Delete are the networks of both sides right before the merge
+	Keep   netip.Prefix
+	Delete netip.Prefix
+}
+
+// MergeWithInfo is a merge func that also gets told why it is called
+type MergeWithInfo func(keep, delete CidrEntry, info MergeInfo)
+
+// MergeIf is a merge func that can decline the merge by returning false, both
+// entries are then kept separate. It runs before anything is merged, so it
+// should only change keep when it returns true
+type MergeIf func(keep, delete CidrEntry, info MergeInfo) bool
+
+// Aggregate merges the covered and adjacent cidr entries, the result is
+// sorted with all IPv4 entries first, followed by all IPv6 entries
 func Aggregate(cidrEntries []CidrEntry, mergeFn Merge) []CidrEntry {
+	return AggregateOf(cidrEntries, mergeFn)
+}
+
+// AggregateOf is the same as Aggregate but keeps the concrete entry type, so
+// neither the merge func nor the caller need to type assert
+func AggregateOf[T CidrEntry](cidrEntries []T, mergeFn func(keep, delete T)) []T {
 	if len(cidrEntries) < 2 {
 		return cidrEntries
 	}
@@ -54,60 +150,220 @@ func Aggregate(cidrEntries []CidrEntry, mergeFn Merge) []CidrEntry {
 	sortIt(cidrs)
 	// add pointer
 	addPointer(cidrs)
-	// unlink the smaller ones that already in bigger ones
-	unlinkCovered(cidrs, mergeFn)
-	// do the aggregate
-	aggregateAdj(cidrs, mergeFn)
 
-	return getEntries(cidrs)
+	merge := func(keep, delete *cidr, _ MergeInfo) bool {
+		mergeFn(cidrEntries[keep.idx], cidrEntries[delete.idx])
+		return true
+	}
+	a := &aggregator{mergeFn: merge}
+	head := a.aggregateList(&cidrs[0])
+
+	return getEntries(head, cidrEntries)
 }
 
-func convertToCidr(cidrEntries []CidrEntry) []cidr {
-	var cidrs []cidr
-	bigOne := big.NewInt(1)
-	var ipnet netip.Prefix
-	// convert
+// aggregateToList aggregates the cidr entries the same way Aggregate does but
+// stops at the sorted list, the entries do not get their network updated. The
+// list is nil when there is no entry
+func aggregateToList(cidrEntries []CidrEntry, mergeFn Merge) (*aggregator, *cidr) {
+	a := &aggregator{
+		mergeFn: func(keep, delete *cidr, _ MergeInfo) bool {
+			mergeFn(cidrEntries[keep.idx], cidrEntries[delete.idx])
+			return true
+		},
+	}
+	if len(cidrEntries) == 0 {
+		return a, nil
+	}
+
+	cidrs := convertToCidr(cidrEntries)
+	sortIt(cidrs)
+	addPointer(cidrs)
+	return a, a.aggregateList(&cidrs[0])
+}
+
+// AggregatePrefixes gives the same result as Aggregate with basic cidr
+// entries, but works on the prefixes directly without any interface or merge
+// func overhead
+func AggregatePrefixes(prefixes []netip.Prefix) []netip.Prefix {
+	if len(prefixes) < 2 {
+		return prefixes
+	}
+	// sort small pointer free keys, moving cidrs around during the sort
+	// costs a write barrier for every pointer in them
+	keys := make([]prefixKey, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		prefix = prefix.Masked()
+		keys = append(keys, prefixKey{
+			startIP: uint128FromAddr(prefix.Addr()),
+			ones:    int32(prefix.Bits()),
+			bits:    int32(prefix.Addr().BitLen()),
+		})
+	}
+	slices.SortFunc(keys, func(a, b prefixKey) int {
+		if a.bits != b.bits {
+			return int(a.bits - b.bits)
+		}
+		if startIPCmp := a.startIP.cmp(b.startIP); startIPCmp != 0 {
+			return startIPCmp
+		}
+		return int(a.ones - b.ones)
+	})
+
+	cidrs := make([]cidr, len(keys))
+	for i, key := range keys {
+		bits, ones := int(key.bits), int(key.ones)
+		netIP := key.startIP.addr(bits)
+		if bits == 0 {
+			// an invalid prefix comes back as the zero value, as with Aggregate
+			netIP = netip.Addr{}
+		}
+		cidrs[i] = cidr{
+			netIP:   netIP,
+			startIP: key.startIP,
+			endIP:   key.startIP.or(hostMask(bits - ones)),
+			ones:    ones,
+			bits:    bits,
+			idx:     i,
+		}
+	}
+	addPointer(cidrs)
+	// no merge func, nothing to report back
+	a := &aggregator{}
+	head := a.aggregateList(&cidrs[0])
+
+	n := 0
+	for currentP := head; currentP != nil; currentP = currentP.next {
+		n++
+	}
+	r := make([]netip.Prefix, 0, n)
+	for currentP := head; currentP != nil; currentP = currentP.next {
+		r = append(r, currentP.prefix())
+	}
+	return r
+}
+
+// prefixKey is what AggregatePrefixes sorts the prefixes by
+type prefixKey struct {
+	startIP uint128
+	ones    int32
+	bits    int32
+}
+
+// GroupByFamily splits the cidr entries into an IPv4 block and an IPv6 block,
+// the relative order within each block is kept
+func GroupByFamily(cidrEntries []CidrEntry) (v4, v6 []CidrEntry) {
 	for _, cidrEntry := range cidrEntries {
-		ipnet = cidrEntry.GetNetwork().Masked()
+		if cidrEntry.GetNetwork().Addr().Is4() {
+			v4 = append(v4, cidrEntry)
+		} else {
+			v6 = append(v6, cidrEntry)
+		}
+	}
+	return v4, v6
+}
+
+func convertToCidr[T CidrEntry](cidrEntries []T) []cidr {
+	cidrs := make([]cidr, 0, len(cidrEntries))
+	// convert
+	for i, cidrEntry := range cidrEntries {
+		cidrs = append(cidrs, newCidr(cidrEntry.GetNetwork(), i))
+	}
 
-		// cover IPv6
-		startIP := big.NewInt(0)
-		startIP.SetBytes(ipnet.Addr().AsSlice())
+	return cidrs
+}
 
-		nextStartIP := big.NewInt(0)
+func newCidr(ipnet netip.Prefix, idx int) cidr {
+	ipnet = ipnet.Masked()
 
-		ones := ipnet.Bits()
-		bits := ipnet.Addr().BitLen()
-		diff := uint(bits) - uint(ones)
+	// cover IPv6
+	startIP := uint128FromAddr(ipnet.Addr())
 
-		nextStartIP.Lsh(bigOne, diff)
-		nextStartIP.Add(nextStartIP, startIP)
+	ones := ipnet.Bits()
+	bits := ipnet.Addr().BitLen()
+	endIP := startIP.or(hostMask(bits - ones))
 
-		cidrs = append(cidrs, cidr{
-			netIP:       ipnet.Addr(),
-			startIP:     startIP,
-			nextStartIP: nextStartIP,
-			ones:        ones,
-			bits:        bits,
-			entry:       cidrEntry,
+	return cidr{
+		netIP:   ipnet.Addr(),
+		startIP: startIP,
+		endIP:   endIP,
+		ones:    ones,
+		bits:    bits,
+		idx:     idx,
+	}
+}
+
+// splitCidr splits c into the pieces with the given prefix length, ones must
+// not be shorter than the prefix length of c
+func splitCidr(c cidr, ones int) []cidr {
+	var pieces []cidr
+	mask := hostMask(c.bits - ones)
+	startIP := c.startIP
+	for {
+		endIP := startIP.or(mask)
+		pieces = append(pieces, cidr{
+			netIP:   startIP.addr(c.bits),
+			startIP: startIP,
+			endIP:   endIP,
+			ones:    ones,
+			bits:    c.bits,
+			idx:     c.idx,
 		})
+		if endIP == c.endIP {
+			return pieces
+		}
+		startIP = endIP.addOne()
 	}
+}
 
-	return cidrs
+// rangeToCidrs returns the minimal cidrs covering exactly start to end, start
+// must not be after end
+func rangeToCidrs(start, end uint128, bits int) []cidr {
+	var r []cidr
+	for {
+		// the biggest block aligned at start and not past end
+		size := start.trailingZeros()
+		if size > bits {
+			size = bits
+		}
+		for start.or(hostMask(size)).cmp(end) > 0 {
+			size--
+		}
+		blockEnd := start.or(hostMask(size))
+		r = append(r, cidr{
+			netIP:   start.addr(bits),
+			startIP: start,
+			endIP:   blockEnd,
+			ones:    bits - size,
+			bits:    bits,
+		})
+		if blockEnd == end {
+			return r
+		}
+		start = blockEnd.addOne()
+	}
 }
 
 func sortIt(cidrs []cidr) {
-	sort.Slice(cidrs, func(i, j int) bool {
-		startIPCmp := cidrs[i].startIP.Cmp(cidrs[j].startIP)
-		if startIPCmp < 0 {
-			return true
-		} else if startIPCmp == 0 && cidrs[i].ones < cidrs[j].ones {
-			return true
-		}
-		return false
+	slices.SortFunc(cidrs, func(a, b cidr) int {
+		return cidrCmp(&a, &b)
 	})
 }
 
+func cidrLess(a, b *cidr) bool {
+	return cidrCmp(a, b) < 0
+}
+
+func cidrCmp(a, b *cidr) int {
+	// IPv4 before IPv6, the two families never interleave
+	if a.bits != b.bits {
+		return a.bits - b.bits
+	}
+	if startIPCmp := a.startIP.cmp(b.startIP); startIPCmp != 0 {
+		return startIPCmp
+	}
+	return a.ones - b.ones
+}
+
 func addPointer(cidrs []cidr) {
 	s := 0
 	e := 1
@@ -119,44 +375,152 @@ func addPointer(cidrs []cidr) {
 	}
 }
 
-func unlinkCovered(cidrs []cidr, mergeFn Merge) {
-	// check already done from Aggregate()
-	currentP := &cidrs[0]
+// aggregateList does the covered and adjacent merges on the sorted list
+// starting from head, and returns the head of the result list
+func (a *aggregator) aggregateList(head *cidr) *cidr {
+	// unlink the smaller ones that already in bigger ones
+	held := a.unlinkCovered(head)
+	if a.stopped {
+		return head
+	}
+	// do the aggregate
+	a.aggregateAdj(head)
+
+	if held != nil && !a.stopped {
+		// the declined covered ones are aggregated among themselves, but
+		// never into the network that declined them
+		held = a.aggregateList(held)
+		if a.trace != nil {
+			for currentP := held; currentP != nil; currentP = currentP.next {
+				a.trace(TraceRejoin, currentP, nil, netip.Prefix{}, false)
+			}
+		}
+		head = mergeLists(head, held)
+	}
+	return head
+}
+
+// unlinkCovered removes the cidrs covered by a previous one, a covered cidr
+// the merge func declines is moved to the returned held list instead. So is
+// a cidr inside a declined one, it only merges with the nearest cidr covering
+// it once the held list is aggregated
+func (a *aggregator) unlinkCovered(head *cidr) (held *cidr) {
+	var heldTail *cidr
+	// the held cidr ending last, every held cidr starts before the next one
+	// so it covers the next one if any held cidr does
+	var heldCoverP *cidr
+	currentP := head
 	nextP := currentP.next
 
+	processed := 0
 	for nextP != nil {
-		if currentP.nextStartIP.Cmp(nextP.nextStartIP) >= 0 {
+		processed++
+		if processed%checkEvery == 0 && !a.tick(PhaseUnlink, processed) {
+			return held
+		}
+		if currentP.bits == nextP.bits &&
+			currentP.endIP.cmp(nextP.endIP) >= 0 {
+			inHeld := heldCoverP != nil &&
+				heldCoverP.bits == nextP.bits &&
+				heldCoverP.endIP.cmp(nextP.endIP) >= 0
 			// run the merge func
-			mergeFn(currentP.entry, nextP.entry)
+			merged := !inHeld
+			if merged && a.mergeFn != nil {
+				kind := MergeCovered
+				if currentP.ones == nextP.ones {
+					kind = MergeDuplicate
+				}
+				merged = a.mergeFn(currentP, nextP, MergeInfo{
+					Kind:   kind,
+					Result: currentP.prefix(),
+					Keep:   currentP.prefix(),
+					Delete: nextP.prefix(),
+				})
+			}
+			if a.trace != nil && !inHeld {
+				a.trace(TraceCovered, currentP, nextP, currentP.prefix(), !merged)
+			}
 			// skip the next
 			currentP.next = nextP.next
 			if nextP.next != nil {
 				nextP.next.prev = currentP
 			}
+			if !merged {
+				// keep it aside, so it does not get in the way of the
+				// adjacent ones
+				if a.trace != nil {
+					coverP := currentP
+					if inHeld {
+						coverP = heldCoverP
+					}
+					a.trace(TraceHeld, coverP, nextP, netip.Prefix{}, false)
+				}
+				if nextP.minOnes <= currentP.ones {
+					nextP.minOnes = currentP.ones + 1
+				}
+				nextP.prev = heldTail
+				nextP.next = nil
+				if heldTail == nil {
+					held = nextP
+				} else {
+					heldTail.next = nextP
+				}
+				heldTail = nextP
+				if !inHeld {
+					heldCoverP = nextP
+				}
+			}
 		} else {
 			// only move current forward if current endIP not cover next endIP
 			currentP = nextP
 		}
 		nextP = currentP.next
 	}
+	a.tick(PhaseUnlink, processed)
+	return held
 }
 
-func aggregateAdj(cidrs []cidr, mergeFn Merge) {
-	// check already done from Aggregate()
-	currentP := &cidrs[0]
+func (a *aggregator) aggregateAdj(head *cidr) {
+	currentP := head
 	nextP := currentP.next
 
+	processed := 0
 	for nextP != nil {
+		processed++
+		if processed%checkEvery == 0 && !a.tick(PhaseMerge, processed) {
+			return
+		}
 
-		if currentP.ones == nextP.ones &&
-			currentP.nextStartIP.Cmp(nextP.startIP) == 0 &&
-			getIPPrefix(currentP.netIP) < currentP.ones {
+		if currentP.bits == nextP.bits &&
+			currentP.ones == nextP.ones &&
+			currentP.endIP.addOne() == nextP.startIP &&
+			getIPPrefix(currentP.netIP) < currentP.ones &&
+			currentP.ones > a.minOnes(currentP.bits) &&
+			currentP.ones > currentP.minOnes &&
+			currentP.ones > nextP.minOnes {
+			// run the merge func
+			merged := a.mergeFn == nil || a.mergeFn(currentP, nextP, MergeInfo{
+				Kind:   MergeAdjacent,
+				Result: netip.PrefixFrom(currentP.netIP, currentP.ones-1),
+				Keep:   currentP.prefix(),
+				Delete: nextP.prefix(),
+			})
+			if a.trace != nil {
+				a.trace(TraceSibling, currentP, nextP, netip.PrefixFrom(currentP.netIP, currentP.ones-1), !merged)
+			}
+			if !merged {
+				// declined, leave both and move forward
+				currentP = nextP
+				nextP = currentP.next
+				continue
+			}
 			// change current endIP and prefix
 			// no need to change the netIP
-			currentP.nextStartIP = nextP.nextStartIP
+			currentP.endIP = nextP.endIP
 			currentP.ones = currentP.ones - 1
-			// run the merge func
-			mergeFn(currentP.entry, nextP.entry)
+			if currentP.minOnes < nextP.minOnes {
+				currentP.minOnes = nextP.minOnes
+			}
 
 			// redo the link
 			currentP.next = nextP.next
@@ -166,6 +530,9 @@ func aggregateAdj(cidrs []cidr, mergeFn Merge) {
 
 			// try to move up if possible
 			if currentP.prev != nil {
+				if a.trace != nil {
+					a.trace(TraceBacktrack, currentP.prev, currentP, netip.Prefix{}, false)
+				}
 				nextP = currentP
 				currentP = currentP.prev
 			} else {
@@ -178,23 +545,50 @@ func aggregateAdj(cidrs []cidr, mergeFn Merge) {
 		currentP = nextP
 		nextP = currentP.next
 	}
+	a.tick(PhaseMerge, processed)
+}
+
+// mergeLists merges two sorted lists into one, on a tie a comes first
+func mergeLists(a, b *cidr) *cidr {
+	var head, tail *cidr
+	for a != nil || b != nil {
+		var p *cidr
+		if a == nil || (b != nil && cidrLess(b, a)) {
+			p, b = b, b.next
+		} else {
+			p, a = a, a.next
+		}
+		p.prev = tail
+		p.next = nil
+		if tail == nil {
+			head = p
+		} else {
+			tail.next = p
+		}
+		tail = p
+	}
+	return head
 }
 
-func getEntries(cidrs []cidr) []CidrEntry {
-	var r []CidrEntry
-	currentP := &cidrs[0]
+func getEntries[T CidrEntry](head *cidr, cidrEntries []T) []T {
+	var r []T
+	currentP := head
 	for currentP != nil {
+		entry := cidrEntries[currentP.idx]
 		// update the entry network
-		prefix := netip.PrefixFrom(currentP.netIP, currentP.ones)
-		currentP.entry.SetNetwork(prefix)
+		entry.SetNetwork(currentP.prefix())
 		// added to results
-		r = append(r, currentP.entry)
+		r = append(r, entry)
 		// move to next
 		currentP = currentP.next
 	}
 	return r
 }
 
+func (c *cidr) prefix() netip.Prefix {
+	return netip.PrefixFrom(c.netIP, c.ones)
+}
+
 func getIPPrefix(ip netip.Addr) int {
 
 	if ip.Is4() {
@@ -45,6 +45,20 @@ type testResults struct {
 	count       int
 }
 
+type notedResults struct {
+	ipnetString string
+	count       int
+	note        string
+}
+
+func notedEntries(in []notedResults) []CidrEntry {
+	var r []CidrEntry
+	for _, s := range in {
+		r = append(r, NewCustomCidrEntry(netip.MustParsePrefix(s.ipnetString), s.count, s.note))
+	}
+	return r
+}
+
 type customCidrEntry struct {
 	ipNet netip.Prefix
 	count int
@@ -93,6 +107,12 @@ func mergeUseDeleteNote(k, d CidrEntry) {
 func mergeDoNothing(_, _ CidrEntry) {
 }
 
+func sameNote(a, b CidrEntry) bool {
+	sa, _ := a.(*customCidrEntry)
+	sb, _ := b.(*customCidrEntry)
+	return sa.note == sb.note
+}
+
 func TestAggregateAddCount(t *testing.T) {
 
 	var got []CidrEntry
@@ -628,6 +648,239 @@ func TestAggregateWithMergeDoNothing65K(t *testing.T) {
 
 }
 
+func TestAggregateOf(t *testing.T) {
+
+	var input = []testResults{
+		{"8.8.9.128/25", 1},
+		{"8.8.8.0/24", 39},
+		{"8.8.9.0/25", 4},
+		{"2001:db8::/64", 2},
+	}
+
+	var want = []testResults{
+		{"8.8.8.0/23", 44},
+		{"2001:db8::/64", 2},
+	}
+
+	var inputCidrs []*customCidrEntry
+	for _, s := range input {
+		inputCidrs = append(inputCidrs, &customCidrEntry{
+			ipNet: netip.MustParsePrefix(s.ipnetString),
+			count: s.count,
+			note:  "US",
+		})
+	}
+
+	got := AggregateOf(inputCidrs, func(keep, delete *customCidrEntry) {
+		keep.count += delete.count
+	})
+
+	var cidrWant []*customCidrEntry
+	for _, s := range want {
+		cidrWant = append(cidrWant, &customCidrEntry{
+			ipNet: netip.MustParsePrefix(s.ipnetString),
+			count: s.count,
+			note:  "US",
+		})
+	}
+
+	if !reflect.DeepEqual(got, cidrWant) {
+		t.Errorf("expect: %+v , but got %+v", cidrWant, got)
+	}
+}
+
+func TestAggregatePrefixes(t *testing.T) {
+
+	for i, c := range []struct {
+		in   []string
+		want []string
+	}{
+		{
+			[]string{"8.8.8.0/24"},
+			[]string{"8.8.8.0/24"},
+		},
+		{
+			[]string{"8.8.8.0/25", "9.9.9.0/25", "8.8.8.128/25"},
+			[]string{"8.8.8.0/24", "9.9.9.0/25"},
+		},
+		{
+			[]string{
+				"192.168.0.0/25", "192.168.0.128/25",
+				"192.168.1.0/24", "192.168.3.0/24", "192.168.4.0/24",
+				"192.168.5.0/26",
+				"192.168.128.0/22", "192.168.132.0/22",
+				"192.168.128.0/21",
+			},
+			[]string{
+				"192.168.0.0/23", "192.168.3.0/24", "192.168.4.0/24",
+				"192.168.5.0/26", "192.168.128.0/21",
+			},
+		},
+		{
+			[]string{
+				"::/0", "2001:db8::/32", "2001:db8::/126", "2001:db8::/127",
+				"ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff/128",
+			},
+			[]string{"::/0"},
+		},
+		{
+			[]string{
+				"192.0.2.160/29", "192.0.2.176/29", "192.0.2.184/29", "192.0.2.168/32",
+				"2001:db8::/64", "2001:db8:0:2::/64", "2001:db8:0:3::/64", "2001:db8:0:1::/64",
+				"192.0.2.171/32", "192.0.2.172/32", "192.0.2.174/32", "192.0.2.169/32",
+				"192.0.2.170/32", "192.0.2.173/32", "192.0.2.175/32", "2001:db8:0:4::/64",
+			},
+			[]string{
+				"192.0.2.160/27", "2001:db8::/62", "2001:db8:0:4::/64",
+			},
+		},
+		// an invalid prefix, empty here, comes back as is
+		{
+			[]string{"8.8.8.128/25", "", "8.8.8.0/25", ""},
+			[]string{"", "8.8.8.0/24"},
+		},
+	} {
+		parse := func(s string) netip.Prefix {
+			if s == "" {
+				return netip.Prefix{}
+			}
+			return netip.MustParsePrefix(s)
+		}
+
+		var prefixes []netip.Prefix
+		var cidrEntries []CidrEntry
+		for _, s := range c.in {
+			prefixes = append(prefixes, parse(s))
+			cidrEntries = append(cidrEntries, NewBasicCidrEntry(parse(s)))
+		}
+
+		var want []netip.Prefix
+		for _, s := range c.want {
+			want = append(want, parse(s))
+		}
+
+		got := AggregatePrefixes(prefixes)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("#%d: expect: %+v , but got %+v", i, want, got)
+		}
+
+		// must be the same as Aggregate with basic entries
+		var fromEntries []netip.Prefix
+		for _, cidrEntry := range Aggregate(cidrEntries, mergeDoNothing) {
+			fromEntries = append(fromEntries, cidrEntry.GetNetwork())
+		}
+		if !reflect.DeepEqual(got, fromEntries) {
+			t.Errorf("#%d: expect same as Aggregate: %+v , but got %+v", i, fromEntries, got)
+		}
+	}
+}
+
+func TestAggregateFamilyOverlap(t *testing.T) {
+
+	for i, c := range []struct {
+		in   []string
+		want []testResults
+	}{
+		// same integer value in both families
+		{
+			[]string{"1.2.3.0/24", "::102:300/120"},
+			[]testResults{
+				{"1.2.3.0/24", 1},
+				{"::102:300/120", 1},
+			},
+		},
+		{
+			[]string{"::102:300/120", "1.2.3.0/24"},
+			[]testResults{
+				{"1.2.3.0/24", 1},
+				{"::102:300/120", 1},
+			},
+		},
+		// same range in both families
+		{
+			[]string{"::/96", "0.0.0.0/0"},
+			[]testResults{
+				{"0.0.0.0/0", 1},
+				{"::/96", 1},
+			},
+		},
+		// IPv6 numerically covers IPv4
+		{
+			[]string{"::/96", "1.2.3.4/32", "::/96"},
+			[]testResults{
+				{"1.2.3.4/32", 1},
+				{"::/96", 2},
+			},
+		},
+		// IPv4 numerically covers IPv6
+		{
+			[]string{"::102:304/128", "1.2.3.0/24"},
+			[]testResults{
+				{"1.2.3.0/24", 1},
+				{"::102:304/128", 1},
+			},
+		},
+		// siblings interleaved across families
+		{
+			[]string{
+				"1.2.3.0/25", "::102:380/121", "1.2.3.128/25", "::102:300/121",
+			},
+			[]testResults{
+				{"1.2.3.0/24", 2},
+				{"::102:300/120", 2},
+			},
+		},
+		// IPv4 end next to IPv6 start
+		{
+			[]string{"::1:0:0/128", "255.255.255.255/32", "::1:0:1/128"},
+			[]testResults{
+				{"255.255.255.255/32", 1},
+				{"::1:0:0/127", 2},
+			},
+		},
+	} {
+		var cidrEntries []CidrEntry
+		var cidrWant []CidrEntry
+
+		for _, s := range c.in {
+			ipnet := netip.MustParsePrefix(s)
+			cidrEntries = append(cidrEntries, NewCustomCidrEntry(ipnet, 1, "US"))
+		}
+
+		for _, s := range c.want {
+			ipnet := netip.MustParsePrefix(s.ipnetString)
+			cidrWant = append(cidrWant, NewCustomCidrEntry(ipnet, s.count, "US"))
+		}
+
+		got := Aggregate(cidrEntries, mergeAddCount)
+
+		if !reflect.DeepEqual(got, cidrWant) {
+			t.Errorf("#%d: expect: %+v , but got %+v", i, cidrWant, got)
+		}
+	}
+}
+
+func TestGroupByFamily(t *testing.T) {
+	var inputCidrs []CidrEntry
+	for _, s := range []string{
+		"2001:db8::/64", "192.0.2.0/24", "::102:300/120", "1.2.3.0/24",
+	} {
+		inputCidrs = append(inputCidrs, NewBasicCidrEntry(netip.MustParsePrefix(s)))
+	}
+
+	v4, v6 := GroupByFamily(inputCidrs)
+
+	wantV4 := []CidrEntry{inputCidrs[1], inputCidrs[3]}
+	wantV6 := []CidrEntry{inputCidrs[0], inputCidrs[2]}
+
+	if !reflect.DeepEqual(v4, wantV4) {
+		t.Errorf("expect: %+v , but got %+v", wantV4, v4)
+	}
+	if !reflect.DeepEqual(v6, wantV6) {
+		t.Errorf("expect: %+v , but got %+v", wantV6, v6)
+	}
+}
+
 func BenchmarkAggregateMergeAddCount(b *testing.B) {
 	input := []string{
 		"192.0.2.160/29", "192.0.2.176/29", "192.0.2.184/29", "192.0.2.168/32",
@@ -732,3 +985,52 @@ func BenchmarkAggregateMergeDoNothing16M(b *testing.B) {
 		_ = Aggregate(cidrEntries, mergeDoNothing)
 	}
 }
+
+func BenchmarkAggregatePrefixes(b *testing.B) {
+	input := []string{
+		"192.0.2.160/29", "192.0.2.176/29", "192.0.2.184/29", "192.0.2.168/32",
+		"192.0.2.0/29", "192.0.2.8/29", "192.0.2.16/29", "192.0.2.24/29",
+		"192.0.2.32/29", "192.0.2.40/29", "192.0.2.48/29", "192.0.2.56/29",
+		"192.0.2.64/29", "192.0.2.72/29", "192.0.2.80/29", "192.0.2.88/29",
+		"2001:db8::/64", "2001:db8:0:2::/64", "2001:db8:0:3::/64", "2001:db8:0:1::/64",
+		"192.0.2.128/29", "192.0.2.136/29", "192.0.2.144/29", "192.0.2.152/29",
+		"192.0.2.192/29", "192.0.2.200/29", "192.0.2.208/29", "192.0.2.216/29",
+		"192.0.2.224/29", "192.0.2.232/29", "192.0.2.240/29", "192.0.2.248/29",
+		"2001:db8:0:4::/64", "192.0.2.171/32", "192.0.2.172/32", "192.0.2.174/32",
+		"192.0.2.169/32", "192.0.2.170/32", "192.0.2.173/32", "192.0.2.175/32",
+		"192.0.2.96/29", "192.0.2.104/29", "192.0.2.112/29", "192.0.2.120/29",
+	}
+
+	var prefixes []netip.Prefix
+	for _, s := range input {
+		prefixes = append(prefixes, netip.MustParsePrefix(s))
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = AggregatePrefixes(prefixes)
+	}
+}
+
+func BenchmarkAggregatePrefixes16M(b *testing.B) {
+	var prefixes []netip.Prefix
+
+	var x, c, d int
+	var bStr, cStr, dStr string
+
+	for x = 0; x < 256; x++ {
+		bStr = strconv.Itoa(x)
+		for c = 0; c < 256; c++ {
+			cStr = strconv.Itoa(c)
+			for d = 0; d < 256; d++ {
+				dStr = strconv.Itoa(d)
+				prefixes = append(prefixes, netip.MustParsePrefix("1."+bStr+"."+cStr+"."+dStr+"/32"))
+			}
+		}
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = AggregatePrefixes(prefixes)
+	}
+}
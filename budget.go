@@ -0,0 +1,309 @@
+package Agg
+
+import (
+	"container/heap"
+	"math/big"
+	"net/netip"
+	"sort"
+)
+
+// AggregateToBudget aggregates the cidr entries like Aggregate, then keeps
+// replacing neighbours with their smallest common supernet until at most
+// maxCount entries are left, each round picks the supernet that adds the
+// fewest addresses not in the input. IPv4 and IPv6 are never merged with each
+// other, so at least one entry per family is left.
+//
+// A supernet takes in every entry inside it and may get merged with a
+// sibling, so a round can remove more than one entry and the result may end
+// up with fewer than maxCount entries.
+//
+// It also returns how many addresses the result covers on top of the input.
+func AggregateToBudget(cidrEntries []CidrEntry, maxCount int, mergeFn Merge) ([]CidrEntry, *big.Int) {
+	overCovered := new(big.Int)
+	if len(cidrEntries) < 2 {
+		return cidrEntries, overCovered
+	}
+
+	cidrs := convertToCidr(cidrEntries)
+	sortIt(cidrs)
+	addPointer(cidrs)
+	b := newBudget(cidrs)
+	a := &aggregator{
+		mergeFn: func(keep, delete *cidr, _ MergeInfo) bool {
+			mergeFn(cidrEntries[keep.idx], cidrEntries[delete.idx])
+			return true
+		},
+	}
+	head := a.aggregateList(&cidrs[0])
+
+	count := 0
+	for currentP := head; currentP != nil; currentP = currentP.next {
+		b.covered.add(b.posOf[currentP.idx], cidrSize(currentP))
+		count++
+	}
+	if count <= maxCount {
+		return getEntries(head, cidrEntries), overCovered
+	}
+	for currentP := head; currentP != nil; currentP = currentP.next {
+		b.addPair(currentP)
+	}
+
+	for count > maxCount && len(b.pairs) > 0 {
+		pair := b.pairs[0]
+		cost := pair.cost
+		first := b.widen(pair.left, pair.super)
+
+		// merge in everything it covers now
+		for p := first.next; p != nil && p.bits == first.bits && p.endIP.cmp(first.endIP) <= 0; p = first.next {
+			a.mergeFn(first, p, MergeInfo{
+				Kind:   MergeCovered,
+				Result: first.prefix(),
+				Keep:   first.prefix(),
+				Delete: p.prefix(),
+			})
+			b.unlink(p)
+			count--
+		}
+
+		// the supernet may have got a sibling, and that one too
+		for {
+			if prevP := first.prev; prevP != nil && isSibling(prevP, first) {
+				b.dropPair(prevP.prev)
+				b.mergeSibling(a, prevP, first)
+				first = prevP
+			} else if nextP := first.next; nextP != nil && isSibling(first, nextP) {
+				b.mergeSibling(a, first, nextP)
+			} else {
+				break
+			}
+			count--
+		}
+
+		// every supernet around it covers more now, so costs less
+		for ones := first.ones - 1; ones >= 0; ones-- {
+			super := netip.PrefixFrom(first.netIP, ones).Masked()
+			if around, ok := b.supers[super]; ok {
+				around.cost = b.cost(super)
+				heap.Fix(&b.pairs, around.index)
+			}
+		}
+		b.addPair(first.prev)
+		b.addPair(first)
+
+		overCovered.Add(overCovered, cost.big())
+	}
+
+	return getEntries(head, cidrEntries), overCovered
+}
+
+// budgetPair is a pair of neighbours, with the smallest common supernet of
+// both and the number of addresses it adds
+type budgetPair struct {
+	left  *cidr
+	super netip.Prefix
+	cost  uint128
+	index int
+}
+
+// budgetHeap keeps the cheapest pair first, on a tie the first one in the list
+type budgetHeap []*budgetPair
+
+func (h budgetHeap) Len() int {
+	return len(h)
+}
+
+func (h budgetHeap) Less(i, j int) bool {
+	if costCmp := h[i].cost.cmp(h[j].cost); costCmp != 0 {
+		return costCmp < 0
+	}
+	return cidrLess(h[i].left, h[j].left)
+}
+
+func (h budgetHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *budgetHeap) Push(x any) {
+	pair := x.(*budgetPair)
+	pair.index = len(*h)
+	*h = append(*h, pair)
+}
+
+func (h *budgetHeap) Pop() any {
+	old := *h
+	pair := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return pair
+}
+
+// budget keeps what AggregateToBudget needs to find the cheapest pair
+// without going through the whole list every round
+type budget struct {
+	// family and start of every sorted cidr before any merge, a cidr only
+	// grows so its own start stays inside it
+	bits   []int
+	starts []uint128
+	// position in the sorted cidrs by input index
+	posOf []int
+
+	// addresses of every cidr still in the list by position
+	covered fenwick
+
+	pairs budgetHeap
+	// the pair by its left cidr position, and by its supernet, no two
+	// pairs of neighbours share the same smallest common supernet
+	pairOf []*budgetPair
+	supers map[netip.Prefix]*budgetPair
+}
+
+func newBudget(cidrs []cidr) *budget {
+	b := &budget{
+		bits:    make([]int, len(cidrs)),
+		starts:  make([]uint128, len(cidrs)),
+		posOf:   make([]int, len(cidrs)),
+		covered: make(fenwick, len(cidrs)+1),
+		pairOf:  make([]*budgetPair, len(cidrs)),
+		supers:  make(map[netip.Prefix]*budgetPair),
+	}
+	for i := range cidrs {
+		b.bits[i] = cidrs[i].bits
+		b.starts[i] = cidrs[i].startIP
+		b.posOf[cidrs[i].idx] = i
+	}
+	return b
+}
+
+// cost returns how many addresses in super are not in any cidr yet
+func (b *budget) cost(super netip.Prefix) uint128 {
+	bits := super.Addr().BitLen()
+	mask := hostMask(bits - super.Bits())
+	superStart := uint128FromAddr(super.Addr())
+	superEnd := superStart.or(mask)
+
+	// the cidrs inside super are the ones starting inside it
+	lo := sort.Search(len(b.starts), func(i int) bool {
+		return b.bits[i] > bits || b.bits[i] == bits && b.starts[i].cmp(superStart) >= 0
+	})
+	hi := sort.Search(len(b.starts), func(i int) bool {
+		return b.bits[i] > bits || b.bits[i] == bits && b.starts[i].cmp(superEnd) > 0
+	})
+	covered := b.covered.sum(hi).sub(b.covered.sum(lo))
+
+	// the size itself does not fit for ::/0 so start from the mask
+	return mask.sub(covered).addOne()
+}
+
+// addPair adds the pair of c and its next one, when of the same family
+func (b *budget) addPair(c *cidr) {
+	if c == nil || c.next == nil || c.bits != c.next.bits {
+		return
+	}
+	// common leading bits of both start IPs within the family, they are
+	// disjoint so this is shorter than both prefix lengths
+	superOnes := c.startIP.xor(c.next.startIP).leadingZeros() - (128 - c.bits)
+	super := netip.PrefixFrom(c.netIP, superOnes).Masked()
+
+	pair := &budgetPair{left: c, super: super, cost: b.cost(super)}
+	heap.Push(&b.pairs, pair)
+	b.pairOf[b.posOf[c.idx]] = pair
+	b.supers[super] = pair
+}
+
+// dropPair removes the pair of c and its next one, if there is one
+func (b *budget) dropPair(c *cidr) {
+	if c == nil {
+		return
+	}
+	pos := b.posOf[c.idx]
+	pair := b.pairOf[pos]
+	if pair == nil {
+		return
+	}
+	heap.Remove(&b.pairs, pair.index)
+	delete(b.supers, pair.super)
+	b.pairOf[pos] = nil
+}
+
+// widen turns the first cidr inside super into super, and returns it
+func (b *budget) widen(c *cidr, super netip.Prefix) *cidr {
+	mask := hostMask(c.bits - super.Bits())
+	superStart := uint128FromAddr(super.Addr())
+	first := c
+	for first.prev != nil && first.prev.bits == c.bits && first.prev.startIP.cmp(superStart) >= 0 {
+		first = first.prev
+	}
+	b.dropPair(first.prev)
+	b.dropPair(first)
+
+	oldSize := cidrSize(first)
+	first.startIP = superStart
+	first.endIP = superStart.or(mask)
+	first.netIP = super.Addr()
+	first.ones = super.Bits()
+	b.covered.add(b.posOf[first.idx], cidrSize(first).sub(oldSize))
+	return first
+}
+
+// unlink takes c out of the list and its addresses out of the count, the one
+// before c must not have a pair left
+func (b *budget) unlink(c *cidr) {
+	b.dropPair(c)
+	b.covered.add(b.posOf[c.idx], uint128{}.sub(cidrSize(c)))
+	c.prev.next = c.next
+	if c.next != nil {
+		c.next.prev = c.prev
+	}
+}
+
+// mergeSibling merges the sibling nextP into c, neither may have a pair with
+// each other left
+func (b *budget) mergeSibling(a *aggregator, c, nextP *cidr) {
+	a.mergeFn(c, nextP, MergeInfo{
+		Kind:   MergeAdjacent,
+		Result: netip.PrefixFrom(c.netIP, c.ones-1),
+		Keep:   c.prefix(),
+		Delete: nextP.prefix(),
+	})
+	size := cidrSize(nextP)
+	b.unlink(nextP)
+	c.endIP = nextP.endIP
+	c.ones--
+	b.covered.add(b.posOf[c.idx], size)
+}
+
+// isSibling tells whether c and nextP are the two halves of their parent
+func isSibling(c, nextP *cidr) bool {
+	return c.bits == nextP.bits &&
+		c.ones == nextP.ones &&
+		c.endIP.addOne() == nextP.startIP &&
+		getIPPrefix(c.netIP) < c.ones
+}
+
+// cidrSize returns the number of addresses in c, zero for ::/0 as it does not
+// fit
+func cidrSize(c *cidr) uint128 {
+	return c.endIP.sub(c.startIP).addOne()
+}
+
+// fenwick is a binary indexed tree of uint128 sums, the arithmetic wraps the
+// same way uint128 does, so a difference of two sums is right as long as it
+// fits
+type fenwick []uint128
+
+// add adds v at position i
+func (f fenwick) add(i int, v uint128) {
+	for i++; i < len(f); i += i & -i {
+		f[i] = f[i].add(v)
+	}
+}
+
+// sum returns the sum of the positions before i
+func (f fenwick) sum(i int) uint128 {
+	var s uint128
+	for ; i > 0; i -= i & -i {
+		s = s.add(f[i])
+	}
+	return s
+}
@@ -0,0 +1,160 @@
+package Agg
+
+import (
+	"math/big"
+	"math/rand"
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+func TestAggregateToBudget(t *testing.T) {
+
+	for i, c := range []struct {
+		in          []string
+		maxCount    int
+		want        []testResults
+		overCovered int64
+	}{
+		// fits already
+		{
+			[]string{"10.0.0.0/25", "10.0.0.128/25", "10.0.2.0/24"},
+			2,
+			[]testResults{
+				{"10.0.0.0/24", 2},
+				{"10.0.2.0/24", 1},
+			},
+			0,
+		},
+		{
+			[]string{"10.0.0.0/24", "10.0.2.0/24"},
+			1,
+			[]testResults{
+				{"10.0.0.0/22", 2},
+			},
+			512,
+		},
+		// pick the cheaper pair
+		{
+			[]string{"10.0.8.0/24", "10.0.0.0/24", "10.0.2.0/24"},
+			2,
+			[]testResults{
+				{"10.0.0.0/22", 2},
+				{"10.0.8.0/24", 1},
+			},
+			512,
+		},
+		// supernet gets a sibling, one round takes out two entries and
+		// ends up below the budget
+		{
+			[]string{"10.0.0.0/25", "10.0.0.192/26", "10.0.1.0/24"},
+			2,
+			[]testResults{
+				{"10.0.0.0/23", 3},
+			},
+			64,
+		},
+		// supernet covers more than the pair
+		{
+			[]string{"10.0.0.0/26", "10.0.0.128/26", "10.0.0.64/27", "10.0.1.0/24"},
+			1,
+			[]testResults{
+				{"10.0.0.0/23", 4},
+			},
+			96,
+		},
+		// never mix the families
+		{
+			[]string{"10.0.0.0/24", "2001:db8::/128", "2001:db8::2/128"},
+			1,
+			[]testResults{
+				{"10.0.0.0/24", 1},
+				{"2001:db8::/126", 2},
+			},
+			2,
+		},
+	} {
+		var cidrEntries []CidrEntry
+		for _, s := range c.in {
+			cidrEntries = append(cidrEntries, NewCustomCidrEntry(netip.MustParsePrefix(s), 1, "US"))
+		}
+
+		var cidrWant []CidrEntry
+		for _, s := range c.want {
+			cidrWant = append(cidrWant, NewCustomCidrEntry(netip.MustParsePrefix(s.ipnetString), s.count, "US"))
+		}
+
+		got, overCovered := AggregateToBudget(cidrEntries, c.maxCount, mergeAddCount)
+
+		if !reflect.DeepEqual(got, cidrWant) {
+			t.Errorf("#%d: expect: %+v , but got %+v", i, cidrWant, got)
+		}
+		if overCovered.Cmp(big.NewInt(c.overCovered)) != 0 {
+			t.Errorf("#%d: expect over covered %d but got %s", i, c.overCovered, overCovered)
+		}
+	}
+}
+
+func TestAggregateToBudgetIPv6OverCovered(t *testing.T) {
+	var cidrEntries []CidrEntry
+	for _, s := range []string{"::/1", "c000::/2"} {
+		cidrEntries = append(cidrEntries, NewBasicCidrEntry(netip.MustParsePrefix(s)))
+	}
+
+	got, overCovered := AggregateToBudget(cidrEntries, 1, mergeDoNothing)
+
+	want := []CidrEntry{NewBasicCidrEntry(netip.MustParsePrefix("::/0"))}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expect: %+v , but got %+v", want, got)
+	}
+
+	// 2^126
+	wantOver := new(big.Int).Lsh(big.NewInt(1), 126)
+	if overCovered.Cmp(wantOver) != 0 {
+		t.Errorf("expect over covered %s but got %s", wantOver, overCovered)
+	}
+}
+
+func TestAggregateToBudgetScattered(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	var cidrEntries []CidrEntry
+	for i := 0; i < 5000; i++ {
+		ip := netip.AddrFrom4([4]byte{byte(r.Intn(256)), byte(r.Intn(256)), byte(r.Intn(256)), byte(r.Intn(256))})
+		cidrEntries = append(cidrEntries, NewCustomCidrEntry(netip.PrefixFrom(ip, 32), 1, "US"))
+	}
+
+	got, _ := AggregateToBudget(cidrEntries, 10, mergeAddCount)
+
+	// may undershoot, but every input is still in there
+	if len(got) == 0 || len(got) > 10 {
+		t.Fatalf("expect at most 10 entries but got %d", len(got))
+	}
+	total := 0
+	for _, g := range got {
+		sg, _ := g.(*customCidrEntry)
+		total += sg.count
+	}
+	if total != 5000 {
+		t.Errorf("expect all 5000 entries merged in but got %d", total)
+	}
+}
+
+func BenchmarkAggregateToBudget(b *testing.B) {
+	r := rand.New(rand.NewSource(1))
+	var prefixes []netip.Prefix
+	for i := 0; i < 5000; i++ {
+		ip := netip.AddrFrom4([4]byte{byte(r.Intn(256)), byte(r.Intn(256)), byte(r.Intn(256)), byte(r.Intn(256))})
+		prefixes = append(prefixes, netip.PrefixFrom(ip, 32))
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		cidrEntries := make([]CidrEntry, 0, len(prefixes))
+		for _, prefix := range prefixes {
+			cidrEntries = append(cidrEntries, NewBasicCidrEntry(prefix))
+		}
+		b.StartTimer()
+		_, _ = AggregateToBudget(cidrEntries, 10, mergeDoNothing)
+	}
+}
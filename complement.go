@@ -0,0 +1,29 @@
+package Agg
+
+import (
+	"net/netip"
+)
+
+// Complement returns the minimal prefixes within the given network that are
+// not covered by any of the cidr entries, entries outside of it are ignored
+func Complement(within netip.Prefix, cidrEntries []CidrEntry) []netip.Prefix {
+	return ComplementAtLeast(within, cidrEntries, within.Addr().BitLen())
+}
+
+// ComplementAtLeast is the same as Complement but only returns the free
+// prefixes of at least the given size, i.e. with a prefix length no longer
+// than maxPrefixLen
+func ComplementAtLeast(within netip.Prefix, cidrEntries []CidrEntry, maxPrefixLen int) []netip.Prefix {
+	if !within.IsValid() {
+		return nil
+	}
+	withinCidr := newCidr(within, 0)
+
+	var r []netip.Prefix
+	subtractList(&withinCidr, toDisjointCidrs(cidrEntries), func(_ *cidr, _ int, piece cidr) {
+		if piece.ones <= maxPrefixLen {
+			r = append(r, piece.prefix())
+		}
+	})
+	return r
+}
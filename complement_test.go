@@ -0,0 +1,85 @@
+package Agg
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+func TestComplement(t *testing.T) {
+
+	for i, c := range []struct {
+		within       string
+		in           []string
+		maxPrefixLen int
+		want         []string
+	}{
+		{
+			"10.0.0.0/22",
+			[]string{"10.0.1.0/24", "10.0.0.0/25"},
+			32,
+			[]string{"10.0.0.128/25", "10.0.2.0/23"},
+		},
+		// only the free /24 or larger
+		{
+			"10.0.0.0/22",
+			[]string{"10.0.1.0/24", "10.0.0.0/25"},
+			24,
+			[]string{"10.0.2.0/23"},
+		},
+		// entries outside or of the other family are ignored
+		{
+			"10.0.0.0/24",
+			[]string{"10.0.1.0/24", "::a00:0/120", "10.0.0.0/26", "9.0.0.0/8"},
+			32,
+			[]string{"10.0.0.64/26", "10.0.0.128/25"},
+		},
+		// fully used
+		{
+			"10.0.0.0/24",
+			[]string{"10.0.0.0/25", "10.0.0.128/25"},
+			32,
+			nil,
+		},
+		{
+			"10.0.0.0/24",
+			[]string{"0.0.0.0/0"},
+			32,
+			nil,
+		},
+		{
+			"2001:db8::/32",
+			[]string{"2001:db8:8000::/33", "2001:db8::/34"},
+			128,
+			[]string{"2001:db8:4000::/34"},
+		},
+		{
+			"::/0",
+			[]string{},
+			128,
+			[]string{"::/0"},
+		},
+	} {
+		var cidrEntries []CidrEntry
+		for _, s := range c.in {
+			cidrEntries = append(cidrEntries, NewBasicCidrEntry(netip.MustParsePrefix(s)))
+		}
+
+		var want []netip.Prefix
+		for _, s := range c.want {
+			want = append(want, netip.MustParsePrefix(s))
+		}
+
+		got := ComplementAtLeast(netip.MustParsePrefix(c.within), cidrEntries, c.maxPrefixLen)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("#%d: expect: %+v , but got %+v", i, want, got)
+		}
+
+		if c.maxPrefixLen == netip.MustParsePrefix(c.within).Addr().BitLen() {
+			got = Complement(netip.MustParsePrefix(c.within), cidrEntries)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("#%d: expect: %+v , but got %+v", i, want, got)
+			}
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package Agg
+
+import (
+	"context"
+)
+
+// checkEvery is how many items a loop processes between two cancellation
+// checks, a power of two so the check stays cheap
+const checkEvery = 1 << 16
+
+// Phase is a step of AggregateContext
+type Phase int
+
+const (
+	// PhaseConvert turns the entries into the internal list
+	PhaseConvert Phase = iota
+	// PhaseSort sorts the list, it can not be stopped half way
+	PhaseSort
+	// PhaseUnlink removes the covered entries
+	PhaseUnlink
+	// PhaseMerge combines the siblings
+	PhaseMerge
+	// PhaseEmit updates the network of the result entries
+	PhaseEmit
+)
+
+func (p Phase) String() string {
+	switch p {
+	case PhaseConvert:
+		return "convert"
+	case PhaseSort:
+		return "sort"
+	case PhaseUnlink:
+		return "unlink"
+	case PhaseMerge:
+		return "merge"
+	case PhaseEmit:
+		return "emit"
+	}
+	return "unknown"
+}
+
+// AggregateContext is the same as Aggregate, but stops when ctx is done and
+// returns ctx.Err(). The context is checked between the phases and every
+// 65536 items within them. When stopped the merge func may have been called
+// for some entries and some networks may have been updated already. It takes
+// no Options, so AggregateWithOptions and the functions built on it can not
+// be stopped this way.
+//
+// progress, when not nil, is called at the same points with the phase and
+// how many items of it are processed so far.
+func AggregateContext(ctx context.Context, cidrEntries []CidrEntry, mergeFn Merge, progress func(phase Phase, processed int)) ([]CidrEntry, error) {
+	step := func(phase Phase, processed int) bool {
+		if progress != nil {
+			progress(phase, processed)
+		}
+		return ctx.Err() == nil
+	}
+
+	if !step(PhaseConvert, 0) {
+		return nil, ctx.Err()
+	}
+	if len(cidrEntries) < 2 {
+		return cidrEntries, nil
+	}
+	cidrs := make([]cidr, 0, len(cidrEntries))
+	for i, cidrEntry := range cidrEntries {
+		if i > 0 && i%checkEvery == 0 && !step(PhaseConvert, i) {
+			return nil, ctx.Err()
+		}
+		cidrs = append(cidrs, newCidr(cidrEntry.GetNetwork(), i))
+	}
+	if !step(PhaseConvert, len(cidrs)) {
+		return nil, ctx.Err()
+	}
+
+	sortIt(cidrs)
+	addPointer(cidrs)
+	if !step(PhaseSort, len(cidrs)) {
+		return nil, ctx.Err()
+	}
+
+	a := &aggregator{
+		mergeFn: func(keep, delete *cidr, _ MergeInfo) bool {
+			mergeFn(cidrEntries[keep.idx], cidrEntries[delete.idx])
+			return true
+		},
+		step: step,
+	}
+	head := a.aggregateList(&cidrs[0])
+	if a.stopped {
+		return nil, ctx.Err()
+	}
+
+	var r []CidrEntry
+	for currentP := head; currentP != nil; currentP = currentP.next {
+		if len(r) > 0 && len(r)%checkEvery == 0 && !step(PhaseEmit, len(r)) {
+			return nil, ctx.Err()
+		}
+		entry := cidrEntries[currentP.idx]
+		entry.SetNetwork(currentP.prefix())
+		r = append(r, entry)
+	}
+	// done, a late cancel no longer matters
+	step(PhaseEmit, len(r))
+	return r, nil
+}
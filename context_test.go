@@ -0,0 +1,68 @@
+package Agg
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+// hostEntries returns n adjacent /32 entries starting at 10.0.0.0
+func hostEntries(n int) []CidrEntry {
+	var cidrEntries []CidrEntry
+	ip := netip.MustParseAddr("10.0.0.0")
+	for i := 0; i < n; i++ {
+		cidrEntries = append(cidrEntries, NewCustomCidrEntry(netip.PrefixFrom(ip, 32), 1, "US"))
+		ip = ip.Next()
+	}
+	return cidrEntries
+}
+
+func TestAggregateContext(t *testing.T) {
+	var progress []Phase
+	got, err := AggregateContext(context.Background(), hostEntries(1<<17), mergeAddCount, func(phase Phase, processed int) {
+		if len(progress) == 0 || progress[len(progress)-1] != phase {
+			progress = append(progress, phase)
+		}
+	})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	want := []CidrEntry{NewCustomCidrEntry(netip.MustParsePrefix("10.0.0.0/15"), 1<<17, "US")}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expect: %+v , but got %+v", want, got)
+	}
+	wantProgress := []Phase{PhaseConvert, PhaseSort, PhaseUnlink, PhaseMerge, PhaseEmit}
+	if !reflect.DeepEqual(progress, wantProgress) {
+		t.Errorf("expect phases %v but got %v", wantProgress, progress)
+	}
+}
+
+func TestAggregateContextCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := AggregateContext(ctx, hostEntries(2), mergeAddCount, nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("expect context.Canceled but got %v", err)
+	}
+
+	// cancel half way through each phase
+	for _, stopAt := range []Phase{PhaseConvert, PhaseUnlink, PhaseMerge} {
+		ctx, cancel := context.WithCancel(context.Background())
+		var last Phase
+		got, err := AggregateContext(ctx, hostEntries(1<<17), mergeAddCount, func(phase Phase, processed int) {
+			last = phase
+			if phase == stopAt && processed > 0 {
+				cancel()
+			}
+		})
+		if !errors.Is(err, context.Canceled) || got != nil {
+			t.Errorf("%s: expect context.Canceled but got %v, %d entries", stopAt, err, len(got))
+		}
+		if last != stopAt {
+			t.Errorf("%s: expect to stop in the phase but stopped in %s", stopAt, last)
+		}
+		cancel()
+	}
+}
@@ -0,0 +1,143 @@
+package Agg
+
+// DiffResult holds what changed between two lists, each entry is a clone so
+// neither input list is written to by Diff besides the merge func
+type DiffResult struct {
+	// Added is the address space only in the new list, with the attributes
+	// of the new entries
+	Added []CidrEntry
+	// Removed is the address space only in the old list, with the attributes
+	// of the old entries
+	Removed []CidrEntry
+	// Changed is the address space in both lists where the old and new
+	// entries are not equal, one entry per such overlap with the attributes
+	// of the new entry
+	Changed []CidrEntry
+}
+
+// Diff compares the address space of the old and new entries at cidr level,
+// so a /23 turning into two /24s with the same attributes is not a change.
+// Within each list only entries equal says are the same get merged with
+// mergeFn, and where entries of one list nest the address belongs to the
+// innermost one, the way a longest prefix match picks it. Of two entries with
+// the same network and different attributes one is picked.
+func Diff(oldEntries, newEntries []CidrEntry, equal func(oldEntry, newEntry CidrEntry) bool, clone func(CidrEntry) CidrEntry, mergeFn Merge) DiffResult {
+	oldPieces := flattenList(aggregateEqualToList(oldEntries, equal, mergeFn))
+	newPieces := flattenList(aggregateEqualToList(newEntries, equal, mergeFn))
+
+	var r DiffResult
+	if len(newPieces) > 0 {
+		addPointer(newPieces)
+		subtractList(&newPieces[0], oldPieces, func(c *cidr, _ int, piece cidr) {
+			r.Added = append(r.Added, clonePiece(newEntries[c.idx], piece, clone))
+		})
+	}
+	if len(oldPieces) > 0 {
+		addPointer(oldPieces)
+		subtractList(&oldPieces[0], newPieces, func(c *cidr, _ int, piece cidr) {
+			r.Removed = append(r.Removed, clonePiece(oldEntries[c.idx], piece, clone))
+		})
+	}
+
+	walkOverlaps(oldPieces, newPieces, func(oldC, newC *cidr, overlap cidr) {
+		if !equal(oldEntries[oldC.idx], newEntries[newC.idx]) {
+			r.Changed = append(r.Changed, clonePiece(newEntries[newC.idx], overlap, clone))
+		}
+	})
+	return r
+}
+
+// aggregateEqualToList is aggregateToList, but declines every merge of two
+// entries equal says are not the same
+func aggregateEqualToList(cidrEntries []CidrEntry, equal func(a, b CidrEntry) bool, mergeFn Merge) *cidr {
+	if len(cidrEntries) == 0 {
+		return nil
+	}
+	a := &aggregator{
+		mergeFn: func(keep, delete *cidr, _ MergeInfo) bool {
+			if !equal(cidrEntries[keep.idx], cidrEntries[delete.idx]) {
+				return false
+			}
+			if mergeFn != nil {
+				mergeFn(cidrEntries[keep.idx], cidrEntries[delete.idx])
+			}
+			return true
+		},
+	}
+	cidrs := convertToCidr(cidrEntries)
+	sortIt(cidrs)
+	addPointer(cidrs)
+	return a.aggregateList(&cidrs[0])
+}
+
+// flattenList turns the sorted list starting at head, where cidrs may nest,
+// into sorted and disjoint pieces. The space of an outer cidr not covered by
+// the inner ones is split into pieces carrying the index of the outer one
+func flattenList(head *cidr) []cidr {
+	type open struct {
+		c *cidr
+		// first address not handed out yet
+		next uint128
+		done bool
+	}
+	var pieces []cidr
+	var stack []open
+	addPieces := func(o *open, end uint128) {
+		for _, piece := range rangeToCidrs(o.next, end, o.c.bits) {
+			piece.idx = o.c.idx
+			pieces = append(pieces, piece)
+		}
+	}
+	closeTop := func() {
+		o := &stack[len(stack)-1]
+		if !o.done {
+			addPieces(o, o.c.endIP)
+		}
+		stack = stack[:len(stack)-1]
+	}
+
+	for currentP := head; currentP != nil; currentP = currentP.next {
+		for len(stack) > 0 {
+			top := stack[len(stack)-1].c
+			if top.bits == currentP.bits && top.endIP.cmp(currentP.endIP) >= 0 {
+				break
+			}
+			closeTop()
+		}
+		if len(stack) > 0 {
+			o := &stack[len(stack)-1]
+			if !o.done {
+				if o.next.cmp(currentP.startIP) < 0 {
+					addPieces(o, currentP.startIP.sub(uint128{lo: 1}))
+				}
+				if currentP.endIP == o.c.endIP {
+					o.done = true
+				} else {
+					o.next = currentP.endIP.addOne()
+				}
+			}
+		}
+		stack = append(stack, open{c: currentP, next: currentP.startIP})
+	}
+	for len(stack) > 0 {
+		closeTop()
+	}
+
+	sortIt(pieces)
+	return pieces
+}
+
+func clonePiece(cidrEntry CidrEntry, piece cidr, clone func(CidrEntry) CidrEntry) CidrEntry {
+	pieceEntry := clone(cidrEntry)
+	pieceEntry.SetNetwork(piece.prefix())
+	return pieceEntry
+}
+
+// listToCidrs copies the list starting at head into a slice
+func listToCidrs(head *cidr) []cidr {
+	var cidrs []cidr
+	for currentP := head; currentP != nil; currentP = currentP.next {
+		cidrs = append(cidrs, *currentP)
+	}
+	return cidrs
+}
@@ -0,0 +1,90 @@
+package Agg
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	for i, c := range []struct {
+		old     []notedResults
+		new     []notedResults
+		added   []notedResults
+		removed []notedResults
+		changed []notedResults
+	}{
+		// re-aggregation is not a change
+		{
+			old: []notedResults{{"10.0.0.0/23", 1, "US"}},
+			new: []notedResults{{"10.0.1.0/24", 1, "US"}, {"10.0.0.0/24", 1, "US"}},
+		},
+		{
+			old:     []notedResults{{"10.0.0.0/23", 1, "US"}, {"192.0.2.0/24", 1, "US"}},
+			new:     []notedResults{{"10.0.0.0/24", 1, "US"}, {"10.0.2.0/24", 1, "US"}, {"192.0.2.0/24", 1, "DE"}},
+			added:   []notedResults{{"10.0.2.0/24", 1, "US"}},
+			removed: []notedResults{{"10.0.1.0/24", 1, "US"}},
+			changed: []notedResults{{"192.0.2.0/24", 1, "DE"}},
+		},
+		// changed inside a bigger one
+		{
+			old:     []notedResults{{"10.0.0.0/16", 1, "US"}},
+			new:     []notedResults{{"10.0.1.0/24", 1, "DE"}, {"2001:db8::/32", 1, "DE"}},
+			added:   []notedResults{{"2001:db8::/32", 1, "DE"}},
+			removed: []notedResults{{"10.0.0.0/24", 1, "US"}, {"10.0.2.0/23", 1, "US"}, {"10.0.4.0/22", 1, "US"}, {"10.0.8.0/21", 1, "US"}, {"10.0.16.0/20", 1, "US"}, {"10.0.32.0/19", 1, "US"}, {"10.0.64.0/18", 1, "US"}, {"10.0.128.0/17", 1, "US"}},
+			changed: []notedResults{{"10.0.1.0/24", 1, "DE"}},
+		},
+		{
+			old:     []notedResults{{"10.0.0.0/24", 1, "US"}},
+			removed: []notedResults{{"10.0.0.0/24", 1, "US"}},
+		},
+		// siblings with different attributes are not merged
+		{
+			old:     []notedResults{{"10.0.0.0/23", 1, "US"}},
+			new:     []notedResults{{"10.0.0.0/24", 1, "US"}, {"10.0.1.0/24", 1, "DE"}},
+			changed: []notedResults{{"10.0.1.0/24", 1, "DE"}},
+		},
+		// neither is a covered one with different attributes
+		{
+			old:     []notedResults{{"10.0.0.0/16", 1, "US"}},
+			new:     []notedResults{{"10.0.0.0/16", 1, "US"}, {"10.0.1.0/24", 1, "DE"}},
+			changed: []notedResults{{"10.0.1.0/24", 1, "DE"}},
+		},
+		// the innermost entry wins on both sides
+		{
+			old:     []notedResults{{"10.0.0.0/16", 1, "US"}, {"10.0.1.0/24", 1, "DE"}, {"10.0.1.0/25", 1, "US"}},
+			new:     []notedResults{{"10.0.0.0/16", 1, "US"}, {"10.0.1.0/24", 1, "DE"}},
+			changed: []notedResults{{"10.0.1.0/25", 1, "DE"}},
+		},
+		{
+			old:   []notedResults{{"10.0.0.0/16", 1, "US"}, {"10.0.1.0/24", 1, "DE"}},
+			new:   []notedResults{{"10.0.0.0/16", 1, "US"}, {"10.0.1.0/24", 1, "DE"}, {"10.2.0.0/24", 1, "FR"}},
+			added: []notedResults{{"10.2.0.0/24", 1, "FR"}},
+		},
+	} {
+		oldEntries, newEntries := notedEntries(c.old), notedEntries(c.new)
+		got := Diff(oldEntries, newEntries, sameNote, cloneCustom, mergeDoNothing)
+
+		if !reflect.DeepEqual(got.Added, notedEntries(c.added)) {
+			t.Errorf("#%d: expect added: %+v , but got %+v", i, c.added, got.Added)
+		}
+		if !reflect.DeepEqual(got.Removed, notedEntries(c.removed)) {
+			t.Errorf("#%d: expect removed: %+v , but got %+v", i, c.removed, got.Removed)
+		}
+		if !reflect.DeepEqual(got.Changed, notedEntries(c.changed)) {
+			t.Errorf("#%d: expect changed: %+v , but got %+v", i, c.changed, got.Changed)
+		}
+
+		// inputs keep their networks
+		for j, s := range c.old {
+			if oldEntries[j].GetNetwork() != netip.MustParsePrefix(s.ipnetString) {
+				t.Errorf("#%d: old entry changed: %+v", i, oldEntries[j])
+			}
+		}
+		for j, s := range c.new {
+			if newEntries[j].GetNetwork() != netip.MustParsePrefix(s.ipnetString) {
+				t.Errorf("#%d: new entry changed: %+v", i, newEntries[j])
+			}
+		}
+	}
+}
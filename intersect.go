@@ -0,0 +1,63 @@
+package Agg
+
+// Intersect returns the address space present in both a and b, aggregated the
+// same way Aggregate would. Each list is aggregated with mergeFn first, then
+// every overlap of an entry from a and an entry from b becomes a new entry
+// made by combine, which decides how the attributes of both sides end up in
+// the result. The new entries are aggregated with mergeFn as well.
+func Intersect(a, b []CidrEntry, combine func(a, b CidrEntry) CidrEntry, mergeFn Merge) []CidrEntry {
+	if len(a) == 0 || len(b) == 0 {
+		return nil
+	}
+	_, aHead := aggregateToList(a, mergeFn)
+	_, bHead := aggregateToList(b, mergeFn)
+
+	var entries []CidrEntry
+	var cidrs []cidr
+	walkOverlaps(listToCidrs(aHead), listToCidrs(bHead), func(aC, bC *cidr, overlap cidr) {
+		overlap.prev, overlap.next = nil, nil
+		overlap.idx = len(entries)
+		entries = append(entries, combine(a[aC.idx], b[bC.idx]))
+		cidrs = append(cidrs, overlap)
+	})
+	if len(cidrs) == 0 {
+		return nil
+	}
+
+	// already sorted
+	addPointer(cidrs)
+	r := &aggregator{
+		mergeFn: func(keep, delete *cidr, _ MergeInfo) bool {
+			mergeFn(entries[keep.idx], entries[delete.idx])
+			return true
+		},
+	}
+	head := r.aggregateList(&cidrs[0])
+	return getEntries(head, entries)
+}
+
+// walkOverlaps calls fn for every overlap of a cidr from a and a cidr from b,
+// in order. Both must be sorted and disjoint within themselves
+func walkOverlaps(a, b []cidr, fn func(aC, bC *cidr, overlap cidr)) {
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		aC, bC := &a[i], &b[j]
+		if aC.bits == bC.bits &&
+			aC.startIP.cmp(bC.endIP) <= 0 && bC.startIP.cmp(aC.endIP) <= 0 {
+			// cidrs either nest or do not overlap, so the overlap is the
+			// smaller one
+			overlap := *aC
+			if bC.ones > aC.ones {
+				overlap = *bC
+			}
+			fn(aC, bC, overlap)
+		}
+
+		// move forward the one ending first
+		if aC.bits < bC.bits || aC.bits == bC.bits && aC.endIP.cmp(bC.endIP) <= 0 {
+			i++
+		} else {
+			j++
+		}
+	}
+}
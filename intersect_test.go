@@ -0,0 +1,87 @@
+package Agg
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+func TestIntersect(t *testing.T) {
+
+	// add up the counts of both sides
+	combine := func(a, b CidrEntry) CidrEntry {
+		sa, _ := a.(*customCidrEntry)
+		sb, _ := b.(*customCidrEntry)
+		return NewCustomCidrEntry(sa.ipNet, sa.count+sb.count, sa.note+sb.note)
+	}
+
+	for i, c := range []struct {
+		a    []string
+		b    []string
+		want []testResults
+	}{
+		{
+			[]string{"10.0.0.0/16"},
+			[]string{"10.0.1.0/24", "10.1.0.0/24"},
+			[]testResults{
+				{"10.0.1.0/24", 2},
+			},
+		},
+		// overlaps next to each other get aggregated
+		{
+			[]string{"10.0.0.0/25", "10.0.1.0/24"},
+			[]string{"10.0.0.0/23"},
+			[]testResults{
+				{"10.0.0.0/25", 2},
+				{"10.0.1.0/24", 2},
+			},
+		},
+		{
+			[]string{"10.0.0.0/25", "10.0.0.128/26", "10.0.0.192/26"},
+			[]string{"10.0.0.0/26", "10.0.0.64/26", "10.0.0.128/25"},
+			[]testResults{
+				{"10.0.0.0/24", 6},
+			},
+		},
+		// a v4 entry never overlaps a v6 one, the /24 is only in the /1
+		{
+			[]string{"10.0.0.0/24", "::/96"},
+			[]string{"10.0.1.0/24", "0.0.0.0/1"},
+			[]testResults{
+				{"10.0.0.0/24", 3},
+			},
+		},
+		{
+			[]string{"10.0.0.0/24", "2001:db8::/32"},
+			[]string{"::/0", "10.0.1.0/24"},
+			[]testResults{
+				{"2001:db8::/32", 2},
+			},
+		},
+		// nothing in common
+		{
+			[]string{"10.0.0.0/24"},
+			[]string{"10.0.1.0/24", "2001:db8::/32"},
+			nil,
+		},
+	} {
+		var a, b []CidrEntry
+		for _, s := range c.a {
+			a = append(a, NewCustomCidrEntry(netip.MustParsePrefix(s), 1, "A"))
+		}
+		for _, s := range c.b {
+			b = append(b, NewCustomCidrEntry(netip.MustParsePrefix(s), 1, "B"))
+		}
+
+		var cidrWant []CidrEntry
+		for _, s := range c.want {
+			cidrWant = append(cidrWant, NewCustomCidrEntry(netip.MustParsePrefix(s.ipnetString), s.count, "AB"))
+		}
+
+		got := Intersect(a, b, combine, mergeAddCount)
+
+		if !reflect.DeepEqual(got, cidrWant) {
+			t.Errorf("#%d: expect: %+v , but got %+v", i, cidrWant, got)
+		}
+	}
+}
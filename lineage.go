@@ -0,0 +1,27 @@
+package Agg
+
+// Absorbed is an input entry folded into an output entry
+type Absorbed struct {
+	// Index of the entry in the input slice
+	Index int
+	Entry CidrEntry
+	// Kind tells how it was folded in: covered, duplicate or as a sibling.
+	// An entry folded into another one that is folded in later on keeps the
+	// kind of its own merge
+	Kind MergeKind
+}
+
+// Lineage tells where an output entry comes from
+type Lineage struct {
+	// Index of the input entry the output entry is, or is cloned from. For a
+	// piece made by Options.SplitShorter it is the index of the split entry
+	Index int
+	// Absorbed are the other input entries folded into the output entry
+	Absorbed []Absorbed
+}
+
+// AggregateWithLineage is the same as AggregateWithOptions, and also returns
+// the lineage of every output entry at the same index
+func AggregateWithLineage(cidrEntries []CidrEntry, mergeFn Merge, opts Options) ([]CidrEntry, []Lineage, error) {
+	return aggregateWithOptions(cidrEntries, nil, mergeFn, opts, true)
+}
@@ -0,0 +1,104 @@
+package Agg
+
+import (
+	"errors"
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+func TestAggregateWithLineage(t *testing.T) {
+	var inputCidrs []CidrEntry
+	for _, s := range []string{
+		"8.8.8.128/26", "8.8.8.0/25", "8.8.8.128/25", "9.9.9.0/24", "8.8.8.0/26",
+	} {
+		inputCidrs = append(inputCidrs, NewCustomCidrEntry(netip.MustParsePrefix(s), 1, "US"))
+	}
+
+	got, lineages, err := AggregateWithLineage(inputCidrs, mergeAddCount, Options{Clone: cloneCustom})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	want := []CidrEntry{
+		NewCustomCidrEntry(netip.MustParsePrefix("8.8.8.0/24"), 4, "US"),
+		NewCustomCidrEntry(netip.MustParsePrefix("9.9.9.0/24"), 1, "US"),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expect: %+v , but got %+v", want, got)
+	}
+
+	wantLineages := []Lineage{
+		{
+			Index: 1,
+			Absorbed: []Absorbed{
+				{Index: 4, Entry: inputCidrs[4], Kind: MergeCovered},
+				{Index: 2, Entry: inputCidrs[2], Kind: MergeAdjacent},
+				{Index: 0, Entry: inputCidrs[0], Kind: MergeCovered},
+			},
+		},
+		{
+			Index: 3,
+		},
+	}
+	if !reflect.DeepEqual(lineages, wantLineages) {
+		t.Errorf("expect: %+v , but got %+v", wantLineages, lineages)
+	}
+}
+
+func TestAggregateWithLineageDeclined(t *testing.T) {
+	var inputCidrs []CidrEntry
+	for _, s := range []string{"10.0.0.0/16", "10.0.1.0/24", "10.0.2.0/24"} {
+		inputCidrs = append(inputCidrs, NewCustomCidrEntry(netip.MustParsePrefix(s), 1, "US"))
+	}
+
+	// decline anything with 10.0.1.0/24
+	_, lineages, err := AggregateWithLineage(inputCidrs, nil, Options{
+		MergeIf: func(_, _ CidrEntry, info MergeInfo) bool {
+			return info.Delete != netip.MustParsePrefix("10.0.1.0/24")
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	wantLineages := []Lineage{
+		{
+			Index: 0,
+			Absorbed: []Absorbed{
+				{Index: 2, Entry: inputCidrs[2], Kind: MergeCovered},
+			},
+		},
+		{
+			Index: 1,
+		},
+	}
+	if !reflect.DeepEqual(lineages, wantLineages) {
+		t.Errorf("expect: %+v , but got %+v", wantLineages, lineages)
+	}
+}
+
+func TestAggregateWithLineageSingle(t *testing.T) {
+	in := NewBasicCidrEntry(netip.MustParsePrefix("10.0.0.0/16"))
+
+	got, lineages, err := AggregateWithLineage([]CidrEntry{in}, nil, Options{})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if len(got) != 1 || got[0] != in {
+		t.Errorf("expect the single input back but got %+v", got)
+	}
+	if !reflect.DeepEqual(lineages, []Lineage{{Index: 0}}) {
+		t.Errorf("expect a single lineage but got %+v", lineages)
+	}
+}
+
+func TestAggregateWithLineageInvalid(t *testing.T) {
+	in := NewBasicCidrEntry(netip.MustParsePrefix("10.0.0.0/16"))
+
+	got, lineages, err := AggregateWithLineage([]CidrEntry{in}, nil, Options{MinPrefixLenV4: 33})
+	if !errors.Is(err, ErrInvalidOptions) || got != nil || lineages != nil {
+		t.Errorf("expect ErrInvalidOptions but got %v, %+v, %+v", err, got, lineages)
+	}
+}
@@ -0,0 +1,41 @@
+package Agg
+
+import (
+	"errors"
+	"net/netip"
+)
+
+// ErrMappedAddr is reported for an IPv4-mapped IPv6 entry under MappedReject
+var ErrMappedAddr = errors.New("ipv4-mapped ipv6 prefix")
+
+// MappedPolicy tells how IPv4-mapped IPv6 prefixes like ::ffff:1.2.3.0/120
+// are handled
+type MappedPolicy int
+
+const (
+	// MappedKeep treats them as IPv6, unrelated to the IPv4 space
+	MappedKeep MappedPolicy = iota
+	// MappedUnmap turns them into the IPv4 prefix they map, so they merge
+	// with the IPv4 entries and come back as IPv4
+	MappedUnmap
+	// MappedReject fails the aggregation on them, AggregateE treats them as
+	// offending entries under its strictness
+	MappedReject
+)
+
+// apply returns the prefix to aggregate under the policy, false when it is
+// rejected
+func (p MappedPolicy) apply(prefix netip.Prefix) (netip.Prefix, bool) {
+	if p == MappedKeep {
+		return prefix, true
+	}
+	// masking keeps the ::ffff: part only for /96 and longer
+	masked := prefix.Masked()
+	if !masked.Addr().Is4In6() {
+		return prefix, true
+	}
+	if p == MappedReject {
+		return prefix, false
+	}
+	return netip.PrefixFrom(masked.Addr().Unmap(), masked.Bits()-96), true
+}
@@ -0,0 +1,139 @@
+package Agg
+
+import (
+	"errors"
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+func TestAggregateMapped(t *testing.T) {
+	input := []testResults{
+		{"1.2.3.0/24", 1},
+		{"::ffff:1.2.3.0/120", 2},
+		{"::ffff:1.2.2.0/120", 4},
+		{"2001:db8::/32", 8},
+	}
+
+	for i, c := range []struct {
+		policy MappedPolicy
+		want   []testResults
+	}{
+		{
+			MappedKeep,
+			[]testResults{{"1.2.3.0/24", 1}, {"::ffff:1.2.2.0/119", 6}, {"2001:db8::/32", 8}},
+		},
+		{
+			MappedUnmap,
+			[]testResults{{"1.2.2.0/23", 7}, {"2001:db8::/32", 8}},
+		},
+	} {
+		var cidrEntries []CidrEntry
+		for _, s := range input {
+			cidrEntries = append(cidrEntries, NewCustomCidrEntry(netip.MustParsePrefix(s.ipnetString), s.count, "US"))
+		}
+
+		var want []CidrEntry
+		for _, r := range c.want {
+			want = append(want, NewCustomCidrEntry(netip.MustParsePrefix(r.ipnetString), r.count, "US"))
+		}
+
+		got, err := AggregateWithOptions(cidrEntries, mergeAddCount, Options{Mapped: c.policy})
+		if err != nil {
+			t.Errorf("#%d: unexpected error %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("#%d: expect: %+v , but got %+v", i, want, got)
+		}
+	}
+}
+
+func TestAggregateMappedSingle(t *testing.T) {
+	in := NewCustomCidrEntry(netip.MustParsePrefix("::ffff:1.2.3.4/128"), 1, "US")
+	want := NewCustomCidrEntry(netip.MustParsePrefix("1.2.3.4/32"), 1, "US")
+
+	got, err := AggregateWithOptions([]CidrEntry{in}, mergeAddCount, Options{Mapped: MappedUnmap})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if !reflect.DeepEqual(got, []CidrEntry{want}) {
+		t.Errorf("expect: %+v , but got %+v", want, got)
+	}
+}
+
+func TestAggregateMappedReject(t *testing.T) {
+	cidrEntries := []CidrEntry{
+		NewCustomCidrEntry(netip.MustParsePrefix("::ffff:1.2.3.0/120"), 1, "US"),
+		NewCustomCidrEntry(netip.MustParsePrefix("1.2.3.0/24"), 1, "US"),
+		NewCustomCidrEntry(netip.MustParsePrefix("::ffff:1.2.2.0/120"), 1, "US"),
+	}
+
+	got, err := AggregateWithOptions(cidrEntries, mergeAddCount, Options{Mapped: MappedReject})
+	var verr *ValidationError
+	if got != nil || !errors.As(err, &verr) || !errors.Is(err, ErrMappedAddr) {
+		t.Fatalf("expect a mapped address error but got %v, %+v", err, got)
+	}
+	if len(verr.Entries) != 2 || verr.Entries[0].Index != 0 || verr.Entries[1].Index != 2 {
+		t.Errorf("expect entries 0 and 2 reported but got %+v", verr.Entries)
+	}
+
+	// nothing is counted for a failed run
+	got, stats, err := AggregateWithStats(cidrEntries, mergeAddCount, Options{Mapped: MappedReject})
+	if got != nil || !errors.Is(err, ErrMappedAddr) || stats.InputCount != 0 {
+		t.Errorf("expect a mapped address error but got %v, %+v, %+v", err, got, stats)
+	}
+}
+
+func TestMappedPolicyApply(t *testing.T) {
+	for i, c := range []struct {
+		in   string
+		want string
+	}{
+		{"::ffff:0.0.0.0/96", "0.0.0.0/0"},
+		{"::ffff:10.0.0.5/120", "10.0.0.0/24"},
+		// not all of it is mapped space
+		{"::ffff:0.0.0.0/95", "::ffff:0.0.0.0/95"},
+		{"::/0", "::/0"},
+		{"10.0.0.0/8", "10.0.0.0/8"},
+	} {
+		got, ok := MappedUnmap.apply(netip.MustParsePrefix(c.in))
+		if !ok || got != netip.MustParsePrefix(c.want) {
+			t.Errorf("#%d: expect %s but got %s", i, c.want, got)
+		}
+	}
+}
+
+func TestAggregateEMapped(t *testing.T) {
+	cidrEntries := []CidrEntry{
+		NewCustomCidrEntry(netip.MustParsePrefix("1.2.3.0/24"), 1, "US"),
+		NewCustomCidrEntry(netip.MustParsePrefix("::ffff:1.2.3.0/120"), 1, "US"),
+		NewCustomCidrEntry(netip.MustParsePrefix("1.2.4.1/24"), 1, "US"),
+	}
+
+	// host bits and mapped prefixes rejected in one call
+	got, err := AggregateE(cidrEntries, mergeAddCount, Options{Mapped: MappedReject}, RejectHostBits)
+	var verr *ValidationError
+	if got != nil || !errors.As(err, &verr) || !errors.Is(err, ErrMappedAddr) || !errors.Is(err, ErrHostBits) {
+		t.Fatalf("expect mapped address and host bits errors but got %v, %+v", err, got)
+	}
+	if len(verr.Entries) != 2 || verr.Entries[0].Index != 1 || verr.Entries[1].Index != 2 {
+		t.Errorf("expect entries 1 and 2 reported but got %+v", verr.Entries)
+	}
+
+	// reported and left out
+	got, err = AggregateE(cidrEntries, mergeAddCount, Options{Mapped: MappedReject, Clone: cloneCustom}, NormalizeAndReport)
+	want := []CidrEntry{
+		NewCustomCidrEntry(netip.MustParsePrefix("1.2.3.0/24"), 1, "US"),
+		NewCustomCidrEntry(netip.MustParsePrefix("1.2.4.0/24"), 1, "US"),
+	}
+	if !errors.As(err, &verr) || len(verr.Entries) != 2 || !reflect.DeepEqual(got, want) {
+		t.Errorf("expect: %+v , but got %+v, %v", want, got, err)
+	}
+
+	got, err = AggregateE(cidrEntries[:2], mergeAddCount, Options{Mapped: MappedUnmap}, RejectHostBits)
+	want = []CidrEntry{NewCustomCidrEntry(netip.MustParsePrefix("1.2.3.0/24"), 2, "US")}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("expect: %+v , but got %+v, %v", want, got, err)
+	}
+}
@@ -0,0 +1,306 @@
+package Agg
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+)
+
+// ErrInvalidOptions is returned for Options or SplitOptions that can not work
+// together or are out of range
+var ErrInvalidOptions = errors.New("invalid options")
+
+// Options tunes AggregateWithOptions, the zero value behaves the same as
+// Aggregate
+type Options struct {
+	// Clone returns a new entry carrying the same attributes as the given
+	// one. When set the input entries are never written to: every output
+	// entry is a clone, only clones are passed as keep to the merge func and
+	// only clones get SetNetwork called, so the inputs stay reusable
+	Clone func(CidrEntry) CidrEntry
+
+	// MergeWithInfo, when set, is called instead of the merge func passed to
+	// AggregateWithOptions, it also gets told why the two entries are merged
+	MergeWithInfo MergeWithInfo
+
+	// MergeIf, when set, is called instead of both MergeWithInfo and the
+	// merge func passed to AggregateWithOptions, and can decline a merge.
+	// A declined covered entry is aggregated only with the other declined
+	// ones, a declined sibling is left as is
+	MergeIf MergeIf
+
+	// Key, when set, splits the entries into groups of the same key, only
+	// entries within a group are merged with each other. The groups are
+	// combined back into one sorted result. The returned key must be
+	// comparable, e.g. a string or a struct of the attributes
+	Key func(CidrEntry) any
+
+	// MinPrefixLenV4 and MinPrefixLenV6 set the shortest prefix length
+	// sibling aggregation may produce for each family, zero means no limit.
+	// They must be within 0 to 32 and 0 to 128
+	MinPrefixLenV4 int
+	MinPrefixLenV6 int
+
+	// SplitShorter makes entries already shorter than the minimum prefix
+	// length get split into pieces of the minimum length, each piece is a
+	// Clone of the entry. It needs Clone, and nothing is aggregated when an
+	// entry has an invalid prefix, the error is an ErrInvalidPrefix
+	SplitShorter bool
+
+	// MaxSplitCount caps the number of pieces SplitShorter makes in total,
+	// DefaultMaxSplitCount when zero. Nothing is aggregated when the pieces
+	// would go over it, the error is an ErrTooManySplits
+	MaxSplitCount int
+
+	// Mapped tells how IPv4-mapped IPv6 entries are handled, it is applied
+	// before sorting so with MappedUnmap an entry and its mapped twin are
+	// merged as duplicates. With MappedReject nothing is aggregated when
+	// there are such entries, the error is a *ValidationError listing every
+	// one, AggregateE can leave them out instead
+	Mapped MappedPolicy
+
+	// Observer, when set, is told about every step of the aggregation
+	Observer Observer
+}
+
+// validate checks the options on their own, before any entry is looked at
+func (o *Options) validate() error {
+	if o.MinPrefixLenV4 < 0 || o.MinPrefixLenV4 > net.IPv4len*8 {
+		return fmt.Errorf("%w: MinPrefixLenV4 %d not within 0 to 32", ErrInvalidOptions, o.MinPrefixLenV4)
+	}
+	if o.MinPrefixLenV6 < 0 || o.MinPrefixLenV6 > net.IPv6len*8 {
+		return fmt.Errorf("%w: MinPrefixLenV6 %d not within 0 to 128", ErrInvalidOptions, o.MinPrefixLenV6)
+	}
+	if o.SplitShorter && o.Clone == nil {
+		return fmt.Errorf("%w: SplitShorter needs Clone", ErrInvalidOptions)
+	}
+	if o.MaxSplitCount < 0 {
+		return fmt.Errorf("%w: negative MaxSplitCount %d", ErrInvalidOptions, o.MaxSplitCount)
+	}
+	return nil
+}
+
+// AggregateWithOptions is the same as Aggregate but with the behaviour tuned
+// by opts, mergeFn may be nil when nothing needs to be done on merge. Nothing
+// is aggregated when the options are invalid, an ErrInvalidOptions, or when
+// SplitShorter goes over MaxSplitCount, an ErrTooManySplits, or meets an
+// invalid prefix, an ErrInvalidPrefix. With MappedReject an IPv4-mapped IPv6
+// entry fails it the same way, as a *ValidationError
+func AggregateWithOptions(cidrEntries []CidrEntry, mergeFn Merge, opts Options) ([]CidrEntry, error) {
+	r, _, err := aggregateWithOptions(cidrEntries, nil, mergeFn, opts, false)
+	return r, err
+}
+
+// aggregateWithOptions does the work for AggregateWithOptions, and keeps track
+// of the lineage of every output entry when asked to. The entries set in skip
+// are left out, every index reported still points into cidrEntries
+func aggregateWithOptions(cidrEntries []CidrEntry, skip []bool, mergeFn Merge, opts Options, withLineage bool) ([]CidrEntry, []Lineage, error) {
+	if err := opts.validate(); err != nil {
+		return nil, nil, err
+	}
+	if len(cidrEntries) < 2 && skip == nil && !opts.SplitShorter && opts.Mapped == MappedKeep && opts.Observer == nil {
+		var lineages []Lineage
+		if withLineage {
+			for i := range cidrEntries {
+				lineages = append(lineages, Lineage{Index: i})
+			}
+		}
+		if opts.Clone == nil {
+			return cidrEntries, lineages, nil
+		}
+		var r []CidrEntry
+		for _, cidrEntry := range cidrEntries {
+			r = append(r, opts.Clone(cidrEntry))
+		}
+		return r, lineages, nil
+	}
+
+	a := &aggregator{
+		minOnesV4: opts.MinPrefixLenV4,
+		minOnesV6: opts.MinPrefixLenV6,
+	}
+
+	// work on a copy of the slice, with Clone set an entry is swapped for its
+	// clone the first time it needs to be written
+	entries := make([]CidrEntry, len(cidrEntries))
+	copy(entries, cidrEntries)
+	cloned := make([]bool, len(entries))
+	own := func(c *cidr) CidrEntry {
+		if opts.Clone != nil && !cloned[c.idx] {
+			entries[c.idx] = opts.Clone(entries[c.idx])
+			cloned[c.idx] = true
+		}
+		return entries[c.idx]
+	}
+
+	// index of the input entry each entry comes from
+	origins := make([]int, len(entries))
+	for i := range origins {
+		origins[i] = i
+	}
+
+	maxSplitCount := opts.MaxSplitCount
+	if maxSplitCount == 0 {
+		maxSplitCount = DefaultMaxSplitCount
+	}
+	cidrs := make([]cidr, 0, len(cidrEntries))
+	count := 0
+	// the entries MappedReject rejects, AggregateE skips them beforehand
+	var verr ValidationError
+	for i, cidrEntry := range cidrEntries {
+		if skip != nil && skip[i] {
+			continue
+		}
+		prefix, ok := opts.Mapped.apply(cidrEntry.GetNetwork())
+		if !ok {
+			verr.Entries = append(verr.Entries, EntryError{Index: i, Entry: cidrEntry, Prefix: cidrEntry.GetNetwork(), Err: ErrMappedAddr})
+			continue
+		}
+		if opts.SplitShorter && !prefix.IsValid() {
+			// it has no family, so no minimum length to split it into
+			return nil, nil, fmt.Errorf("%w: entry %d", ErrInvalidPrefix, i)
+		}
+		c := newCidr(prefix, i)
+		if !opts.SplitShorter || c.ones >= a.minOnes(c.bits) {
+			cidrs = append(cidrs, c)
+			continue
+		}
+		// counted first, so nothing is cloned for nothing
+		if count, ok = addSplitCount(count, a.minOnes(c.bits)-c.ones, maxSplitCount); !ok {
+			return nil, nil, fmt.Errorf("%w: more than %d", ErrTooManySplits, maxSplitCount)
+		}
+		// every piece gets its own clone
+		for _, piece := range splitCidr(c, a.minOnes(c.bits)) {
+			clone := opts.Clone(cidrEntry)
+			clone.SetNetwork(piece.prefix())
+			entries = append(entries, clone)
+			cloned = append(cloned, true)
+			origins = append(origins, i)
+			piece.idx = len(entries) - 1
+			cidrs = append(cidrs, piece)
+		}
+	}
+	if len(verr.Entries) > 0 {
+		return nil, nil, &verr
+	}
+	if len(cidrs) == 0 {
+		return nil, nil, nil
+	}
+	sortIt(cidrs)
+	if opts.Observer != nil {
+		for i := range cidrs {
+			opts.Observer.Observe(TraceEvent{
+				Kind:   TraceSorted,
+				Index:  origins[cidrs[i].idx],
+				Prefix: cidrs[i].prefix(),
+			})
+		}
+		a.trace = func(kind TraceKind, c, other *cidr, result netip.Prefix, declined bool) {
+			e := TraceEvent{
+				Kind:     kind,
+				Index:    origins[c.idx],
+				Prefix:   c.prefix(),
+				Result:   result,
+				Declined: declined,
+			}
+			if other != nil {
+				e.OtherIndex = origins[other.idx]
+				e.Other = other.prefix()
+			}
+			opts.Observer.Observe(e)
+		}
+	}
+
+	var absorbed [][]Absorbed
+	if withLineage {
+		absorbed = make([][]Absorbed, len(entries))
+	}
+	a.mergeFn = func(keep, delete *cidr, info MergeInfo) bool {
+		switch {
+		case opts.MergeIf != nil:
+			if !opts.MergeIf(own(keep), entries[delete.idx], info) {
+				return false
+			}
+		case opts.MergeWithInfo != nil:
+			opts.MergeWithInfo(own(keep), entries[delete.idx], info)
+		case mergeFn != nil:
+			mergeFn(own(keep), entries[delete.idx])
+		}
+		if withLineage {
+			// delete and everything it absorbed so far move to keep
+			origin := origins[delete.idx]
+			absorbed[keep.idx] = append(absorbed[keep.idx], Absorbed{
+				Index: origin,
+				Entry: cidrEntries[origin],
+				Kind:  info.Kind,
+			})
+			absorbed[keep.idx] = append(absorbed[keep.idx], absorbed[delete.idx]...)
+			absorbed[delete.idx] = nil
+		}
+		return true
+	}
+	var head *cidr
+	if opts.Key == nil {
+		addPointer(cidrs)
+		head = a.aggregateList(&cidrs[0])
+	} else {
+		head = a.aggregatePartitions(cidrs, entries, opts.Key)
+	}
+
+	// entries never merged still need a clone before the network is set
+	var lineages []Lineage
+	for currentP := head; currentP != nil; currentP = currentP.next {
+		own(currentP)
+		if opts.Observer != nil {
+			opts.Observer.Observe(TraceEvent{
+				Kind:   TraceEmit,
+				Index:  origins[currentP.idx],
+				Prefix: currentP.prefix(),
+			})
+		}
+		if withLineage {
+			lineages = append(lineages, Lineage{
+				Index:    origins[currentP.idx],
+				Absorbed: absorbed[currentP.idx],
+			})
+		}
+	}
+	return getEntries(head, entries), lineages, nil
+}
+
+// aggregatePartitions links the sorted cidrs into one list per key, then
+// aggregates each list on its own and merges them back into one
+func (a *aggregator) aggregatePartitions(cidrs []cidr, entries []CidrEntry, key func(CidrEntry) any) *cidr {
+	tails := make(map[any]*cidr)
+	var heads []*cidr
+	for i := range cidrs {
+		currentP := &cidrs[i]
+		k := key(entries[currentP.idx])
+		if tail, ok := tails[k]; ok {
+			tail.next = currentP
+			currentP.prev = tail
+		} else {
+			heads = append(heads, currentP)
+		}
+		tails[k] = currentP
+	}
+
+	for i, head := range heads {
+		heads[i] = a.aggregateList(head)
+	}
+	// merge pairwise until a single list is left
+	for len(heads) > 1 {
+		n := 0
+		for i := 0; i < len(heads); i += 2 {
+			if i+1 < len(heads) {
+				heads[n] = mergeLists(heads[i], heads[i+1])
+			} else {
+				heads[n] = heads[i]
+			}
+			n++
+		}
+		heads = heads[:n]
+	}
+	return heads[0]
+}
@@ -0,0 +1,484 @@
+package Agg
+
+import (
+	"errors"
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func cloneCustom(c CidrEntry) CidrEntry {
+	sc, _ := c.(*customCidrEntry)
+	clone := *sc
+	return &clone
+}
+
+func TestAggregateWithOptionsClone(t *testing.T) {
+
+	var input = []testResults{
+		{"8.8.9.128/25", 1},
+		{"8.8.8.0/24", 39},
+		{"8.8.8.0/25", 2},
+		{"8.8.9.0/25", 4},
+		{"9.9.9.0/24", 7},
+	}
+
+	var want = []testResults{
+		{"8.8.8.0/23", 46},
+		{"9.9.9.0/24", 7},
+	}
+
+	var inputCidrs []CidrEntry
+	var inputCopy []CidrEntry
+	for _, s := range input {
+		ipnet := netip.MustParsePrefix(s.ipnetString)
+		inputCidrs = append(inputCidrs, NewCustomCidrEntry(ipnet, s.count, "US"))
+		inputCopy = append(inputCopy, NewCustomCidrEntry(ipnet, s.count, "US"))
+	}
+
+	var cidrWant []CidrEntry
+	for _, s := range want {
+		ipnet := netip.MustParsePrefix(s.ipnetString)
+		cidrWant = append(cidrWant, NewCustomCidrEntry(ipnet, s.count, "US"))
+	}
+
+	opts := Options{Clone: cloneCustom}
+
+	// run twice, the second run must see the same input
+	for run := 0; run < 2; run++ {
+		got, err := AggregateWithOptions(inputCidrs, mergeAddCount, opts)
+		if err != nil {
+			t.Fatalf("run %d: unexpected error %v", run, err)
+		}
+
+		if !reflect.DeepEqual(got, cidrWant) {
+			t.Errorf("run %d: expect: %+v , but got %+v", run, cidrWant, got)
+		}
+		if !reflect.DeepEqual(inputCidrs, inputCopy) {
+			t.Errorf("run %d: input changed: %+v", run, inputCidrs)
+		}
+		for _, g := range got {
+			for _, in := range inputCidrs {
+				if g == in {
+					t.Errorf("run %d: output %+v is an input entry", run, g)
+				}
+			}
+		}
+	}
+}
+
+func TestAggregateWithOptionsCloneSingle(t *testing.T) {
+	in := NewCustomCidrEntry(netip.MustParsePrefix("8.8.8.0/24"), 1, "US")
+
+	got, err := AggregateWithOptions([]CidrEntry{in}, mergeAddCount, Options{Clone: cloneCustom})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if len(got) != 1 || got[0] == in {
+		t.Fatalf("expect a single clone but got %+v", got)
+	}
+	if !reflect.DeepEqual(got[0], in) {
+		t.Errorf("expect: %+v , but got %+v", in, got[0])
+	}
+}
+
+func TestAggregateWithOptionsZero(t *testing.T) {
+	var inputCidrs []CidrEntry
+	for _, s := range []string{"8.8.8.0/25", "8.8.8.128/25"} {
+		inputCidrs = append(inputCidrs, NewBasicCidrEntry(netip.MustParsePrefix(s)))
+	}
+
+	got, err := AggregateWithOptions(inputCidrs, mergeDoNothing, Options{})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	want := []CidrEntry{NewBasicCidrEntry(netip.MustParsePrefix("8.8.8.0/24"))}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expect: %+v , but got %+v", want, got)
+	}
+}
+
+func TestAggregateWithOptionsMergeWithInfo(t *testing.T) {
+	var inputCidrs []CidrEntry
+	for _, s := range []string{
+		"8.8.8.128/26", "8.8.8.0/25", "8.8.8.128/25", "8.8.8.0/25",
+	} {
+		inputCidrs = append(inputCidrs, NewCustomCidrEntry(netip.MustParsePrefix(s), 1, "US"))
+	}
+
+	var gotInfo []MergeInfo
+	opts := Options{
+		// only add up the sibling merges
+		MergeWithInfo: func(keep, delete CidrEntry, info MergeInfo) {
+			gotInfo = append(gotInfo, info)
+			if info.Kind == MergeAdjacent {
+				mergeAddCount(keep, delete)
+			}
+		},
+	}
+
+	got, err := AggregateWithOptions(inputCidrs, nil, opts)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	want := []CidrEntry{NewCustomCidrEntry(netip.MustParsePrefix("8.8.8.0/24"), 2, "US")}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expect: %+v , but got %+v", want, got)
+	}
+
+	wantInfo := []MergeInfo{
+		{
+			Kind:   MergeDuplicate,
+			Result: netip.MustParsePrefix("8.8.8.0/25"),
+			Keep:   netip.MustParsePrefix("8.8.8.0/25"),
+			Delete: netip.MustParsePrefix("8.8.8.0/25"),
+		},
+		{
+			Kind:   MergeCovered,
+			Result: netip.MustParsePrefix("8.8.8.128/25"),
+			Keep:   netip.MustParsePrefix("8.8.8.128/25"),
+			Delete: netip.MustParsePrefix("8.8.8.128/26"),
+		},
+		{
+			Kind:   MergeAdjacent,
+			Result: netip.MustParsePrefix("8.8.8.0/24"),
+			Keep:   netip.MustParsePrefix("8.8.8.0/25"),
+			Delete: netip.MustParsePrefix("8.8.8.128/25"),
+		},
+	}
+	if !reflect.DeepEqual(gotInfo, wantInfo) {
+		t.Errorf("expect: %+v , but got %+v", wantInfo, gotInfo)
+	}
+}
+
+func TestMergeKindString(t *testing.T) {
+	for k, want := range map[MergeKind]string{
+		MergeCovered:   "covered",
+		MergeDuplicate: "duplicate",
+		MergeAdjacent:  "adjacent",
+		MergeKind(42):  "unknown",
+	} {
+		if got := k.String(); got != want {
+			t.Errorf("expect %s but got %s", want, got)
+		}
+	}
+}
+
+func TestAggregateWithOptionsMergeIf(t *testing.T) {
+
+	// only merge when the note is the same
+	mergeSameNote := func(keep, delete CidrEntry, _ MergeInfo) bool {
+		sk, _ := keep.(*customCidrEntry)
+		sd, _ := delete.(*customCidrEntry)
+		if sk.note != sd.note {
+			return false
+		}
+		sk.count += sd.count
+		return true
+	}
+
+	for i, c := range []struct {
+		in   []notedResults
+		want []notedResults
+	}{
+		// declined siblings, later siblings still merge
+		{
+			[]notedResults{
+				{"10.0.0.0/25", 1, "US"}, {"10.0.0.128/25", 1, "DE"},
+				{"10.0.1.0/25", 1, "DE"}, {"10.0.1.128/25", 1, "DE"},
+			},
+			[]notedResults{
+				{"10.0.0.0/25", 1, "US"}, {"10.0.0.128/25", 1, "DE"},
+				{"10.0.1.0/24", 2, "DE"},
+			},
+		},
+		// declined covered ones aggregate among themselves
+		{
+			[]notedResults{
+				{"10.0.0.0/16", 1, "US"}, {"10.0.1.0/25", 1, "DE"},
+				{"10.0.1.128/25", 1, "DE"}, {"10.0.2.0/24", 1, "US"},
+				{"10.1.0.0/16", 1, "US"},
+			},
+			[]notedResults{
+				{"10.0.0.0/15", 3, "US"}, {"10.0.1.0/24", 2, "DE"},
+			},
+		},
+		// declined covered one between siblings
+		{
+			[]notedResults{
+				{"10.0.0.0/25", 1, "US"}, {"10.0.0.64/26", 1, "DE"},
+				{"10.0.0.128/26", 1, "US"}, {"10.0.0.192/26", 1, "US"},
+			},
+			[]notedResults{
+				{"10.0.0.0/24", 3, "US"}, {"10.0.0.64/26", 1, "DE"},
+			},
+		},
+		// covered ones inside a declined one only merge with it
+		{
+			[]notedResults{
+				{"10.0.0.0/16", 1, "US"}, {"10.0.1.0/24", 1, "DE"},
+				{"10.0.1.0/25", 1, "US"}, {"10.0.1.128/26", 1, "DE"},
+				{"10.0.2.0/24", 1, "US"},
+			},
+			[]notedResults{
+				{"10.0.0.0/16", 2, "US"}, {"10.0.1.0/24", 2, "DE"},
+				{"10.0.1.0/25", 1, "US"},
+			},
+		},
+		// declined covered ones never grow into the network declining them
+		{
+			[]notedResults{
+				{"10.0.0.0/24", 1, "A"}, {"10.0.0.0/25", 1, "B"},
+				{"10.0.0.128/25", 1, "B"},
+			},
+			[]notedResults{
+				{"10.0.0.0/24", 1, "A"}, {"10.0.0.0/25", 1, "B"},
+				{"10.0.0.128/25", 1, "B"},
+			},
+		},
+		// declined duplicates
+		{
+			[]notedResults{
+				{"10.0.0.0/24", 1, "US"}, {"10.0.0.0/24", 1, "US"},
+				{"2001:db8::/32", 1, "US"}, {"2001:db8::/32", 1, "DE"},
+			},
+			[]notedResults{
+				{"10.0.0.0/24", 2, "US"},
+				{"2001:db8::/32", 1, "US"}, {"2001:db8::/32", 1, "DE"},
+			},
+		},
+	} {
+		got, err := AggregateWithOptions(notedEntries(c.in), nil, Options{MergeIf: mergeSameNote})
+		if err != nil {
+			t.Errorf("#%d: unexpected error %v", i, err)
+			continue
+		}
+
+		if len(got) != len(c.want) {
+			t.Errorf("#%d: expect %d results but got %+v", i, len(c.want), got)
+			continue
+		}
+		for j, w := range c.want {
+			sg, _ := got[j].(*customCidrEntry)
+			if sg.ipNet != netip.MustParsePrefix(w.ipnetString) || sg.count != w.count {
+				t.Errorf("#%d: expect %+v at %d but got %+v", i, w, j, sg)
+			}
+			// notes of duplicates come back in any order
+			if sg.ipNet.String() != "2001:db8::/32" && sg.note != w.note {
+				t.Errorf("#%d: expect %+v at %d but got %+v", i, w, j, sg)
+			}
+		}
+	}
+}
+
+func TestAggregateWithOptionsKey(t *testing.T) {
+	in := []notedResults{
+		{"10.0.1.0/24", 1, "DE"}, {"10.0.0.0/24", 1, "US"},
+		{"10.0.1.0/25", 1, "US"}, {"10.0.1.128/25", 1, "US"},
+		{"10.0.0.0/25", 1, "DE"}, {"10.0.0.128/25", 1, "DE"},
+		{"10.0.2.0/24", 1, "FR"}, {"2001:db8::/33", 1, "US"},
+		{"2001:db8:8000::/33", 1, "US"}, {"2001:db8::/32", 1, "DE"},
+	}
+	want := []notedResults{
+		{"10.0.0.0/23", 3, "US"}, {"10.0.0.0/23", 3, "DE"},
+		{"10.0.2.0/24", 1, "FR"}, {"2001:db8::/32", 1, "DE"},
+		{"2001:db8::/32", 2, "US"},
+	}
+
+	got, err := AggregateWithOptions(notedEntries(in), mergeAddCount, Options{
+		Key: func(c CidrEntry) any {
+			sc, _ := c.(*customCidrEntry)
+			return sc.note
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("expect %d results but got %+v", len(want), got)
+	}
+
+	// entries with the same network come back in any order
+	gotSet := make(map[notedResults]int)
+	for j, g := range got {
+		sg, _ := g.(*customCidrEntry)
+		if sg.ipNet != netip.MustParsePrefix(want[j].ipnetString) {
+			t.Errorf("expect %s at %d but got %s", want[j].ipnetString, j, sg.ipNet)
+		}
+		gotSet[notedResults{sg.ipNet.String(), sg.count, sg.note}]++
+	}
+	for _, w := range want {
+		if gotSet[w] != 1 {
+			t.Errorf("expect %+v in %+v", w, got)
+		}
+	}
+}
+
+func TestAggregateWithOptionsMinPrefixLen(t *testing.T) {
+
+	for i, c := range []struct {
+		in       []string
+		split    bool
+		maxSplit int
+		want     []testResults
+	}{
+		{
+			[]string{"10.0.0.0/17", "10.0.128.0/17", "10.1.0.0/16"},
+			false,
+			0,
+			[]testResults{
+				{"10.0.0.0/16", 2},
+				{"10.1.0.0/16", 1},
+			},
+		},
+		{
+			[]string{"2001:db8::/33", "2001:db8:8000::/33", "2001:db9::/32"},
+			false,
+			0,
+			[]testResults{
+				{"2001:db8::/32", 2},
+				{"2001:db9::/32", 1},
+			},
+		},
+		// shorter ones left as is
+		{
+			[]string{"10.0.0.0/15", "10.1.0.0/24", "2001:db8::/31"},
+			false,
+			0,
+			[]testResults{
+				{"10.0.0.0/15", 2},
+				{"2001:db8::/31", 1},
+			},
+		},
+		// shorter ones split
+		{
+			[]string{"10.0.0.0/15", "10.1.0.0/24", "2001:db8::/31"},
+			true,
+			0,
+			[]testResults{
+				{"10.0.0.0/16", 1},
+				{"10.1.0.0/16", 2},
+				{"2001:db8::/32", 1},
+				{"2001:db9::/32", 1},
+			},
+		},
+		// exactly at the cap
+		{
+			[]string{"10.0.0.0/14", "10.4.0.0/15"},
+			true,
+			6,
+			[]testResults{
+				{"10.0.0.0/16", 1},
+				{"10.1.0.0/16", 1},
+				{"10.2.0.0/16", 1},
+				{"10.3.0.0/16", 1},
+				{"10.4.0.0/16", 1},
+				{"10.5.0.0/16", 1},
+			},
+		},
+	} {
+		var inputCidrs []CidrEntry
+		for _, s := range c.in {
+			inputCidrs = append(inputCidrs, NewCustomCidrEntry(netip.MustParsePrefix(s), 1, "US"))
+		}
+
+		var cidrWant []CidrEntry
+		for _, s := range c.want {
+			cidrWant = append(cidrWant, NewCustomCidrEntry(netip.MustParsePrefix(s.ipnetString), s.count, "US"))
+		}
+
+		got, err := AggregateWithOptions(inputCidrs, mergeAddCount, Options{
+			Clone:          cloneCustom,
+			MinPrefixLenV4: 16,
+			MinPrefixLenV6: 32,
+			SplitShorter:   c.split,
+			MaxSplitCount:  c.maxSplit,
+		})
+		if err != nil {
+			t.Errorf("#%d: unexpected error %v", i, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(got, cidrWant) {
+			t.Errorf("#%d: expect: %+v , but got %+v", i, cidrWant, got)
+		}
+		for j, s := range c.in {
+			if inputCidrs[j].GetNetwork() != netip.MustParsePrefix(s) {
+				t.Errorf("#%d: input changed: %+v", i, inputCidrs[j])
+			}
+		}
+	}
+}
+
+func TestAggregateWithOptionsInvalid(t *testing.T) {
+	for i, c := range []struct {
+		in      []string
+		opts    Options
+		wantErr error
+	}{
+		{[]string{"10.0.0.0/30"}, Options{MinPrefixLenV4: 33}, ErrInvalidOptions},
+		{[]string{"10.0.0.0/30"}, Options{MinPrefixLenV4: -1}, ErrInvalidOptions},
+		{[]string{"2001:db8::/32"}, Options{MinPrefixLenV6: 129}, ErrInvalidOptions},
+		{[]string{"10.0.0.0/8"}, Options{MinPrefixLenV4: 16, SplitShorter: true}, ErrInvalidOptions},
+		{[]string{"10.0.0.0/8"}, Options{Clone: cloneCustom, MaxSplitCount: -1}, ErrInvalidOptions},
+		// the pieces go over the cap
+		{
+			[]string{"10.0.0.0/14", "10.4.0.0/15", "10.6.0.0/16"},
+			Options{Clone: cloneCustom, MinPrefixLenV4: 16, SplitShorter: true, MaxSplitCount: 5},
+			ErrTooManySplits,
+		},
+		{
+			[]string{"::/0"},
+			Options{Clone: cloneCustom, MinPrefixLenV6: 32, SplitShorter: true},
+			ErrTooManySplits,
+		},
+	} {
+		var inputCidrs []CidrEntry
+		for _, s := range c.in {
+			inputCidrs = append(inputCidrs, NewCustomCidrEntry(netip.MustParsePrefix(s), 1, "US"))
+		}
+
+		got, err := AggregateWithOptions(inputCidrs, mergeAddCount, c.opts)
+		if !errors.Is(err, c.wantErr) || got != nil {
+			t.Errorf("#%d: expect %v but got %v, %d entries", i, c.wantErr, err, len(got))
+		}
+
+		got, err = AggregateE(inputCidrs, mergeAddCount, c.opts, RejectInvalid)
+		if !errors.Is(err, c.wantErr) || got != nil {
+			t.Errorf("#%d: expect %v from AggregateE but got %v, %d entries", i, c.wantErr, err, len(got))
+		}
+
+		for j, s := range c.in {
+			if inputCidrs[j].GetNetwork() != netip.MustParsePrefix(s) {
+				t.Errorf("#%d: input changed: %+v", i, inputCidrs[j])
+			}
+		}
+	}
+}
+
+func TestAggregateWithOptionsSplitInvalidPrefix(t *testing.T) {
+	// an invalid prefix has no family to split it in, whatever the floor
+	for i, minLenV6 := range []int{0, 3, 48} {
+		inputCidrs := []CidrEntry{
+			NewCustomCidrEntry(netip.Prefix{}, 1, "US"),
+			NewCustomCidrEntry(netip.MustParsePrefix("10.0.0.0/24"), 1, "US"),
+		}
+
+		got, err := AggregateWithOptions(inputCidrs, nil, Options{
+			Clone:          cloneCustom,
+			MinPrefixLenV6: minLenV6,
+			SplitShorter:   true,
+		})
+		if !errors.Is(err, ErrInvalidPrefix) || got != nil {
+			t.Errorf("#%d: expect ErrInvalidPrefix but got %v, %+v", i, err, got)
+		}
+		if err != nil && !strings.Contains(err.Error(), "entry 0") {
+			t.Errorf("#%d: expect the error to name entry 0 but got %v", i, err)
+		}
+	}
+}
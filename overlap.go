@@ -0,0 +1,69 @@
+package Agg
+
+// Overlap is a pair of entries where one covers the other, Kind is either
+// MergeCovered or MergeDuplicate
+type Overlap struct {
+	Kind MergeKind
+	// Covering is the entry with the bigger or same network, at index
+	// CoveringIndex of the input
+	Covering      CidrEntry
+	CoveringIndex int
+	// Covered is the entry inside the network of Covering, at index
+	// CoveredIndex of the input
+	Covered      CidrEntry
+	CoveredIndex int
+}
+
+// FindOverlaps returns every pair of entries where one covers the other or
+// both have the same network, and equal reports their attributes differ. It
+// only reads the entries, nothing is merged and no network is changed. With
+// nested entries every covering one is paired, so a /8 holding a /16 holding
+// a /24 can give three pairs. The pairs are sorted by the covered entry, then
+// from the biggest covering entry down.
+func FindOverlaps(cidrEntries []CidrEntry, equal func(a, b CidrEntry) bool) []Overlap {
+	if len(cidrEntries) < 2 {
+		return nil
+	}
+	cidrs := convertToCidr(cidrEntries)
+	sortIt(cidrs)
+
+	var r []Overlap
+	// the cidrs covering the current one, biggest first. Cidrs either nest
+	// or do not overlap, so an open one ending before the current start is
+	// done with
+	var open []*cidr
+	for i := range cidrs {
+		currentP := &cidrs[i]
+		for len(open) > 0 {
+			top := open[len(open)-1]
+			if top.bits == currentP.bits && top.endIP.cmp(currentP.startIP) >= 0 {
+				break
+			}
+			open = open[:len(open)-1]
+		}
+
+		for _, coverP := range open {
+			if equal(cidrEntries[coverP.idx], cidrEntries[currentP.idx]) {
+				continue
+			}
+			kind := MergeCovered
+			coverIdx, coveredIdx := coverP.idx, currentP.idx
+			if coverP.ones == currentP.ones {
+				kind = MergeDuplicate
+				// the sort does not keep the input order of duplicates
+				if coverIdx > coveredIdx {
+					coverIdx, coveredIdx = coveredIdx, coverIdx
+				}
+			}
+			r = append(r, Overlap{
+				Kind:          kind,
+				Covering:      cidrEntries[coverIdx],
+				CoveringIndex: coverIdx,
+				Covered:       cidrEntries[coveredIdx],
+				CoveredIndex:  coveredIdx,
+			})
+		}
+		open = append(open, currentP)
+	}
+	return r
+}
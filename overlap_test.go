@@ -0,0 +1,68 @@
+package Agg
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+func TestFindOverlaps(t *testing.T) {
+	type pair struct {
+		kind          MergeKind
+		coveringIndex int
+		coveredIndex  int
+	}
+
+	for i, c := range []struct {
+		in   []notedResults
+		want []pair
+	}{
+		{
+			[]notedResults{{"10.0.0.0/16", 1, "US"}, {"10.0.1.0/24", 1, "DE"}, {"10.0.2.0/24", 1, "US"}},
+			[]pair{{MergeCovered, 0, 1}},
+		},
+		// every covering entry is paired
+		{
+			[]notedResults{{"10.0.1.0/24", 1, "DE"}, {"10.0.0.0/16", 1, "US"}, {"10.0.0.0/8", 1, "FR"}},
+			[]pair{{MergeCovered, 2, 1}, {MergeCovered, 2, 0}, {MergeCovered, 1, 0}},
+		},
+		{
+			[]notedResults{{"10.0.0.0/24", 1, "US"}, {"10.0.0.0/24", 1, "DE"}, {"10.0.0.0/24", 1, "US"}},
+			[]pair{{MergeDuplicate, 0, 1}, {MergeDuplicate, 1, 2}},
+		},
+		// host bits do not matter
+		{
+			[]notedResults{{"10.0.0.5/24", 1, "US"}, {"10.0.0.0/25", 1, "DE"}},
+			[]pair{{MergeCovered, 0, 1}},
+		},
+		// adjacent and other family entries do not overlap
+		{
+			[]notedResults{{"10.0.0.0/24", 1, "US"}, {"10.0.1.0/24", 1, "DE"}, {"::/0", 1, "FR"}, {"2001:db8::/32", 1, "DE"}},
+			[]pair{{MergeCovered, 2, 3}},
+		},
+		{
+			[]notedResults{{"0.0.0.0/0", 1, "US"}, {"10.0.0.0/8", 1, "US"}},
+			nil,
+		},
+	} {
+		cidrEntries := notedEntries(c.in)
+
+		var got []pair
+		for _, o := range FindOverlaps(cidrEntries, sameNote) {
+			if o.Covering != cidrEntries[o.CoveringIndex] || o.Covered != cidrEntries[o.CoveredIndex] {
+				t.Errorf("#%d: entry does not match its index: %+v", i, o)
+			}
+			got = append(got, pair{o.Kind, o.CoveringIndex, o.CoveredIndex})
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("#%d: expect: %+v , but got %+v", i, c.want, got)
+		}
+
+		// read only
+		for j, n := range c.in {
+			if cidrEntries[j].GetNetwork() != netip.MustParsePrefix(n.ipnetString) {
+				t.Errorf("#%d: input changed: %+v", i, cidrEntries[j])
+			}
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package Agg
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+)
+
+// ErrInvalidRange is returned for a range with an invalid address, addresses
+// of different families, or a start after the end
+var ErrInvalidRange = errors.New("invalid ip range")
+
+// RangeEntry is an entry covering a start to end ip range instead of a prefix
+type RangeEntry interface {
+	// GetRange returns the first and the last address of the range
+	GetRange() (start, end netip.Addr)
+	// NewCidrEntry returns a new cidr entry for the prefix, carrying a copy
+	// of the attributes of the range
+	NewCidrEntry(prefix netip.Prefix) CidrEntry
+}
+
+// RangeToPrefixes returns the minimal prefixes covering exactly start to end,
+// both included
+func RangeToPrefixes(start, end netip.Addr) ([]netip.Prefix, error) {
+	cidrs, err := rangeCidrs(start, end)
+	if err != nil {
+		return nil, err
+	}
+	prefixes := make([]netip.Prefix, 0, len(cidrs))
+	for i := range cidrs {
+		prefixes = append(prefixes, cidrs[i].prefix())
+	}
+	return prefixes, nil
+}
+
+// RangesToCidrEntries splits every range entry into its minimal prefixes, and
+// returns one cidr entry per prefix
+func RangesToCidrEntries(rangeEntries []RangeEntry) ([]CidrEntry, error) {
+	var r []CidrEntry
+	for i, rangeEntry := range rangeEntries {
+		cidrs, err := rangeCidrs(rangeEntry.GetRange())
+		if err != nil {
+			return nil, fmt.Errorf("range entry %d: %w", i, err)
+		}
+		for j := range cidrs {
+			r = append(r, rangeEntry.NewCidrEntry(cidrs[j].prefix()))
+		}
+	}
+	return r, nil
+}
+
+// AggregateRanges splits the range entries into cidr entries and aggregates
+// them the same way Aggregate does
+func AggregateRanges(rangeEntries []RangeEntry, mergeFn Merge) ([]CidrEntry, error) {
+	cidrEntries, err := RangesToCidrEntries(rangeEntries)
+	if err != nil {
+		return nil, err
+	}
+	return Aggregate(cidrEntries, mergeFn), nil
+}
+
+// Range is a start to end ip range, both included, Entry carries the
+// attributes of every prefix folded into the range
+type Range struct {
+	Start netip.Addr
+	End   netip.Addr
+	Entry CidrEntry
+}
+
+// ToRanges aggregates the cidr entries the same way Aggregate does, then folds
+// the contiguous prefixes of the same family into maximal ranges. The entry of
+// the first prefix of a range is kept with its network updated, mergeFn is
+// called to fold each following one into it.
+func ToRanges(cidrEntries []CidrEntry, mergeFn Merge) []Range {
+	_, head := aggregateToList(cidrEntries, mergeFn)
+
+	var r []Range
+	currentP := head
+	for currentP != nil {
+		entry := cidrEntries[currentP.idx]
+		entry.SetNetwork(currentP.prefix())
+		endIP := currentP.endIP
+
+		// fold in the following ones as long as they are contiguous
+		nextP := currentP.next
+		for nextP != nil && nextP.bits == currentP.bits && endIP.addOne() == nextP.startIP {
+			mergeFn(entry, cidrEntries[nextP.idx])
+			endIP = nextP.endIP
+			nextP = nextP.next
+		}
+
+		r = append(r, Range{
+			Start: currentP.netIP,
+			End:   endIP.addr(currentP.bits),
+			Entry: entry,
+		})
+		currentP = nextP
+	}
+	return r
+}
+
+func rangeCidrs(start, end netip.Addr) ([]cidr, error) {
+	if !start.IsValid() || !end.IsValid() || start.BitLen() != end.BitLen() {
+		return nil, fmt.Errorf("%w: %s - %s", ErrInvalidRange, start, end)
+	}
+	startIP := uint128FromAddr(start)
+	endIP := uint128FromAddr(end)
+	if startIP.cmp(endIP) > 0 {
+		return nil, fmt.Errorf("%w: %s - %s", ErrInvalidRange, start, end)
+	}
+	return rangeToCidrs(startIP, endIP, start.BitLen()), nil
+}
@@ -0,0 +1,172 @@
+package Agg
+
+import (
+	"errors"
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+func TestRangeToPrefixes(t *testing.T) {
+
+	for i, c := range []struct {
+		start string
+		end   string
+		want  []string
+	}{
+		{"10.0.0.0", "10.0.0.255", []string{"10.0.0.0/24"}},
+		{"10.0.0.5", "10.0.0.5", []string{"10.0.0.5/32"}},
+		{
+			"10.0.0.1", "10.0.1.2",
+			[]string{
+				"10.0.0.1/32", "10.0.0.2/31", "10.0.0.4/30", "10.0.0.8/29",
+				"10.0.0.16/28", "10.0.0.32/27", "10.0.0.64/26", "10.0.0.128/25",
+				"10.0.1.0/31", "10.0.1.2/32",
+			},
+		},
+		{"0.0.0.0", "255.255.255.255", []string{"0.0.0.0/0"}},
+		{"255.255.255.254", "255.255.255.255", []string{"255.255.255.254/31"}},
+		{"::", "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff", []string{"::/0"}},
+		{
+			"2001:db8::", "2001:db8:0:2::ffff",
+			[]string{"2001:db8::/63", "2001:db8:0:2::/112"},
+		},
+	} {
+		got, err := RangeToPrefixes(netip.MustParseAddr(c.start), netip.MustParseAddr(c.end))
+		if err != nil {
+			t.Errorf("#%d: unexpected error %v", i, err)
+			continue
+		}
+
+		var want []netip.Prefix
+		for _, s := range c.want {
+			want = append(want, netip.MustParsePrefix(s))
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("#%d: expect: %+v , but got %+v", i, want, got)
+		}
+	}
+}
+
+func TestRangeToPrefixesInvalid(t *testing.T) {
+	for i, c := range []struct {
+		start netip.Addr
+		end   netip.Addr
+	}{
+		{netip.MustParseAddr("10.0.0.2"), netip.MustParseAddr("10.0.0.1")},
+		{netip.MustParseAddr("10.0.0.1"), netip.MustParseAddr("::ffff:10.0.0.2")},
+		{netip.Addr{}, netip.MustParseAddr("10.0.0.1")},
+	} {
+		_, err := RangeToPrefixes(c.start, c.end)
+		if !errors.Is(err, ErrInvalidRange) {
+			t.Errorf("#%d: expect ErrInvalidRange but got %v", i, err)
+		}
+	}
+}
+
+type customRangeEntry struct {
+	start netip.Addr
+	end   netip.Addr
+	note  string
+}
+
+func (c *customRangeEntry) GetRange() (netip.Addr, netip.Addr) {
+	return c.start, c.end
+}
+
+func (c *customRangeEntry) NewCidrEntry(prefix netip.Prefix) CidrEntry {
+	return NewCustomCidrEntry(prefix, 1, c.note)
+}
+
+func TestAggregateRanges(t *testing.T) {
+	rangeEntries := []RangeEntry{
+		&customRangeEntry{netip.MustParseAddr("10.0.0.128"), netip.MustParseAddr("10.0.1.255"), "US"},
+		&customRangeEntry{netip.MustParseAddr("10.0.0.0"), netip.MustParseAddr("10.0.0.127"), "US"},
+	}
+
+	cidrEntries, err := RangesToCidrEntries(rangeEntries)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	want := []CidrEntry{
+		NewCustomCidrEntry(netip.MustParsePrefix("10.0.0.128/25"), 1, "US"),
+		NewCustomCidrEntry(netip.MustParsePrefix("10.0.1.0/24"), 1, "US"),
+		NewCustomCidrEntry(netip.MustParsePrefix("10.0.0.0/25"), 1, "US"),
+	}
+	if !reflect.DeepEqual(cidrEntries, want) {
+		t.Errorf("expect: %+v , but got %+v", want, cidrEntries)
+	}
+
+	got, err := AggregateRanges(rangeEntries, mergeAddCount)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	want = []CidrEntry{NewCustomCidrEntry(netip.MustParsePrefix("10.0.0.0/23"), 3, "US")}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expect: %+v , but got %+v", want, got)
+	}
+
+	_, err = AggregateRanges([]RangeEntry{
+		&customRangeEntry{netip.MustParseAddr("10.0.0.1"), netip.MustParseAddr("10.0.0.0"), "US"},
+	}, mergeAddCount)
+	if !errors.Is(err, ErrInvalidRange) {
+		t.Errorf("expect ErrInvalidRange but got %v", err)
+	}
+}
+
+func TestToRanges(t *testing.T) {
+	type testRange struct {
+		start string
+		end   string
+		count int
+	}
+
+	for i, c := range []struct {
+		in   []string
+		want []testRange
+	}{
+		{
+			[]string{"10.0.2.0/23", "10.0.1.0/24", "10.0.5.0/24", "10.0.1.128/25"},
+			[]testRange{
+				{"10.0.1.0", "10.0.3.255", 3},
+				{"10.0.5.0", "10.0.5.255", 1},
+			},
+		},
+		// siblings are still aggregated
+		{
+			[]string{"10.0.0.0/25", "10.0.0.128/25"},
+			[]testRange{
+				{"10.0.0.0", "10.0.0.255", 2},
+			},
+		},
+		// never across the families
+		{
+			[]string{"255.255.255.255/32", "::1:0:0/128", "::1:0:1/128", "::1:0:2/127", "ffff::/16"},
+			[]testRange{
+				{"255.255.255.255", "255.255.255.255", 1},
+				{"::1:0:0", "::1:0:3", 3},
+				{"ffff::", "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff", 1},
+			},
+		},
+		{
+			[]string{},
+			nil,
+		},
+	} {
+		var cidrEntries []CidrEntry
+		for _, s := range c.in {
+			cidrEntries = append(cidrEntries, NewCustomCidrEntry(netip.MustParsePrefix(s), 1, "US"))
+		}
+
+		got := ToRanges(cidrEntries, mergeAddCount)
+
+		var gotRanges []testRange
+		for _, g := range got {
+			sg, _ := g.Entry.(*customCidrEntry)
+			gotRanges = append(gotRanges, testRange{g.Start.String(), g.End.String(), sg.count})
+		}
+		if !reflect.DeepEqual(gotRanges, c.want) {
+			t.Errorf("#%d: expect: %+v , but got %+v", i, c.want, gotRanges)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package Agg
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+	"net"
+)
+
+// DefaultMaxSplitCount is the cap on the number of entries Split returns when
+// SplitOptions.MaxCount is not set
+const DefaultMaxSplitCount = 1 << 20
+
+// ErrTooManySplits is returned when Split would go over its cap
+var ErrTooManySplits = errors.New("split produces too many entries")
+
+// SplitOptions tunes Split
+type SplitOptions struct {
+	// TargetLenV4 and TargetLenV6 are the prefix lengths to split into for
+	// each family, entries already as long are left as is
+	TargetLenV4 int
+	TargetLenV6 int
+
+	// Clone returns a new entry carrying the same attributes as the given
+	// one, every piece of a split entry is a clone
+	Clone func(CidrEntry) CidrEntry
+
+	// MaxCount caps the number of entries Split returns, DefaultMaxSplitCount
+	// when zero
+	MaxCount int
+}
+
+// validate checks the options on their own, before any entry is looked at
+func (o *SplitOptions) validate() error {
+	if o.TargetLenV4 < 0 || o.TargetLenV4 > net.IPv4len*8 {
+		return fmt.Errorf("%w: TargetLenV4 %d not within 0 to 32", ErrInvalidOptions, o.TargetLenV4)
+	}
+	if o.TargetLenV6 < 0 || o.TargetLenV6 > net.IPv6len*8 {
+		return fmt.Errorf("%w: TargetLenV6 %d not within 0 to 128", ErrInvalidOptions, o.TargetLenV6)
+	}
+	if o.Clone == nil {
+		return fmt.Errorf("%w: Split needs Clone", ErrInvalidOptions)
+	}
+	if o.MaxCount < 0 {
+		return fmt.Errorf("%w: negative MaxCount %d", ErrInvalidOptions, o.MaxCount)
+	}
+	return nil
+}
+
+// Split is the reverse of Aggregate, every entry shorter than the target
+// prefix length of its family is split into pieces of the target length. The
+// pieces come in the order of the input, and the input entries are never
+// written to. Nothing is returned when the result would be over the cap, an
+// ErrTooManySplits, when the options are invalid, an ErrInvalidOptions, or
+// when an entry has an invalid prefix, an ErrInvalidPrefix.
+func Split(cidrEntries []CidrEntry, opts SplitOptions) ([]CidrEntry, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	for i, cidrEntry := range cidrEntries {
+		if !cidrEntry.GetNetwork().IsValid() {
+			return nil, fmt.Errorf("%w: entry %d", ErrInvalidPrefix, i)
+		}
+	}
+	maxCount := opts.MaxCount
+	if maxCount == 0 {
+		maxCount = DefaultMaxSplitCount
+	}
+
+	// count first, so nothing is cloned for nothing
+	cidrs := convertToCidr(cidrEntries)
+	targetLens := make([]int, len(cidrs))
+	count := 0
+	for i := range cidrs {
+		c := &cidrs[i]
+		targetLen := opts.TargetLenV6
+		if c.bits == 32 {
+			targetLen = opts.TargetLenV4
+		}
+		targetLens[i] = targetLen
+
+		var ok bool
+		if count, ok = addSplitCount(count, targetLen-c.ones, maxCount); !ok {
+			return nil, fmt.Errorf("%w: more than %d", ErrTooManySplits, maxCount)
+		}
+	}
+
+	r := make([]CidrEntry, 0, count)
+	for i := range cidrs {
+		if cidrs[i].ones >= targetLens[i] {
+			r = append(r, cidrEntries[i])
+			continue
+		}
+		for _, piece := range splitCidr(cidrs[i], targetLens[i]) {
+			clone := opts.Clone(cidrEntries[i])
+			clone.SetNetwork(piece.prefix())
+			r = append(r, clone)
+		}
+	}
+	return r, nil
+}
+
+// addSplitCount adds the 2^diff pieces of a split to count, or a single entry
+// when diff is not positive. It is false when the sum goes over maxCount
+func addSplitCount(count, diff, maxCount int) (int, bool) {
+	n := 1
+	if diff > 0 {
+		// shift maxCount down instead of 1 up, so nothing overflows
+		if diff >= bits.UintSize || maxCount>>diff == 0 {
+			return count, false
+		}
+		n = 1 << diff
+	}
+	if n > maxCount-count {
+		return count, false
+	}
+	return count + n, true
+}
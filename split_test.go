@@ -0,0 +1,158 @@
+package Agg
+
+import (
+	"errors"
+	"math/bits"
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplit(t *testing.T) {
+
+	for i, c := range []struct {
+		in   []string
+		want []string
+	}{
+		{
+			[]string{"10.0.0.0/22"},
+			[]string{"10.0.0.0/24", "10.0.1.0/24", "10.0.2.0/24", "10.0.3.0/24"},
+		},
+		// already long enough, and input order kept
+		{
+			[]string{"10.0.5.0/25", "10.0.0.0/23", "10.0.9.0/24"},
+			[]string{"10.0.5.0/25", "10.0.0.0/24", "10.0.1.0/24", "10.0.9.0/24"},
+		},
+		// host bits are dropped
+		{
+			[]string{"10.0.0.5/23"},
+			[]string{"10.0.0.0/24", "10.0.1.0/24"},
+		},
+		{
+			[]string{"2001:db8::/46", "255.255.255.0/23"},
+			[]string{
+				"2001:db8::/48", "2001:db8:1::/48", "2001:db8:2::/48", "2001:db8:3::/48",
+				"255.255.254.0/24", "255.255.255.0/24",
+			},
+		},
+		{
+			[]string{},
+			[]string{},
+		},
+	} {
+		var cidrEntries []CidrEntry
+		for _, s := range c.in {
+			cidrEntries = append(cidrEntries, NewCustomCidrEntry(netip.MustParsePrefix(s), 1, "US"))
+		}
+
+		want := []CidrEntry{}
+		for _, s := range c.want {
+			want = append(want, NewCustomCidrEntry(netip.MustParsePrefix(s), 1, "US"))
+		}
+
+		got, err := Split(cidrEntries, SplitOptions{
+			TargetLenV4: 24,
+			TargetLenV6: 48,
+			Clone:       cloneCustom,
+		})
+		if err != nil {
+			t.Errorf("#%d: unexpected error %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("#%d: expect: %+v , but got %+v", i, want, got)
+		}
+
+		// input untouched
+		for j, s := range c.in {
+			if cidrEntries[j].GetNetwork() != netip.MustParsePrefix(s) {
+				t.Errorf("#%d: input changed: %+v", i, cidrEntries[j])
+			}
+		}
+	}
+}
+
+func TestSplitCap(t *testing.T) {
+	for i, c := range []struct {
+		in       string
+		maxCount int
+	}{
+		{"10.0.0.0/22", 3},
+		{"2001:db8::/32", 0},
+		{"::/0", 0},
+	} {
+		cidrEntries := []CidrEntry{NewCustomCidrEntry(netip.MustParsePrefix(c.in), 1, "US")}
+
+		got, err := Split(cidrEntries, SplitOptions{
+			TargetLenV4: 24,
+			TargetLenV6: 64,
+			Clone:       cloneCustom,
+			MaxCount:    c.maxCount,
+		})
+		if !errors.Is(err, ErrTooManySplits) || got != nil {
+			t.Errorf("#%d: expect ErrTooManySplits but got %v, %+v", i, err, got)
+		}
+	}
+}
+
+func TestSplitInvalid(t *testing.T) {
+	cidrEntries := []CidrEntry{NewCustomCidrEntry(netip.MustParsePrefix("10.0.0.0/8"), 1, "US")}
+	for i, opts := range []SplitOptions{
+		{TargetLenV4: 33, Clone: cloneCustom},
+		{TargetLenV4: -1, Clone: cloneCustom},
+		{TargetLenV6: 129, Clone: cloneCustom},
+		{TargetLenV4: 16},
+		{TargetLenV4: 16, Clone: cloneCustom, MaxCount: -1},
+	} {
+		got, err := Split(cidrEntries, opts)
+		if !errors.Is(err, ErrInvalidOptions) || got != nil {
+			t.Errorf("#%d: expect ErrInvalidOptions but got %v, %+v", i, err, got)
+		}
+	}
+}
+
+func TestSplitInvalidPrefix(t *testing.T) {
+	cidrEntries := []CidrEntry{
+		NewCustomCidrEntry(netip.MustParsePrefix("10.0.0.0/23"), 1, "US"),
+		NewCustomCidrEntry(netip.Prefix{}, 1, "US"),
+	}
+
+	got, err := Split(cidrEntries, SplitOptions{
+		TargetLenV4: 24,
+		TargetLenV6: 2,
+		Clone:       cloneCustom,
+	})
+	if !errors.Is(err, ErrInvalidPrefix) || got != nil {
+		t.Errorf("expect ErrInvalidPrefix but got %v, %+v", err, got)
+	}
+	if err != nil && !strings.Contains(err.Error(), "entry 1") {
+		t.Errorf("expect the error to name entry 1 but got %v", err)
+	}
+}
+
+func TestAddSplitCount(t *testing.T) {
+	maxInt := int(^uint(0) >> 1)
+	for i, c := range []struct {
+		count, diff, maxCount int
+		want                  int
+		wantOK                bool
+	}{
+		{0, 0, 1, 1, true},
+		{0, -3, 1, 1, true},
+		{1, 0, 1, 1, false},
+		{0, 2, 4, 4, true},
+		{1, 2, 4, 1, false},
+		// the cap decides, not the size of the shift
+		{0, 20, 1 << 20, 1 << 20, true},
+		{0, bits.UintSize - 2, maxInt, 1 << (bits.UintSize - 2), true},
+		{0, bits.UintSize - 1, maxInt, 0, false},
+		{0, 200, maxInt, 0, false},
+		{maxInt, 0, maxInt, maxInt, false},
+	} {
+		got, ok := addSplitCount(c.count, c.diff, c.maxCount)
+		if ok != c.wantOK || (ok && got != c.want) {
+			t.Errorf("#%d: expect %d, %v but got %d, %v", i, c.want, c.wantOK, got, ok)
+		}
+	}
+}
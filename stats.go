@@ -0,0 +1,103 @@
+package Agg
+
+import (
+	"math/big"
+	"net/netip"
+)
+
+// PrefixHistogram counts entries per prefix length, indexed by the length
+type PrefixHistogram struct {
+	V4 [33]int
+	V6 [129]int
+}
+
+func (h *PrefixHistogram) add(prefix netip.Prefix) {
+	if !prefix.IsValid() {
+		return
+	}
+	if prefix.Addr().Is4() {
+		h.V4[prefix.Bits()]++
+	} else {
+		h.V6[prefix.Bits()]++
+	}
+}
+
+// Stats tells how effective an aggregation was
+type Stats struct {
+	InputCount  int
+	OutputCount int
+	// Covered is how many entries were dropped as covered by, or a duplicate
+	// of, another entry
+	Covered int
+	// SiblingMerges is how many times two siblings were combined into their
+	// parent
+	SiblingMerges int
+	// AddressesV4 and AddressesV6 are how many addresses the result covers
+	// per family, an address in more than one entry is counted once
+	AddressesV4 *big.Int
+	AddressesV6 *big.Int
+	// Before and After are the prefix lengths of the input and the result
+	Before PrefixHistogram
+	After  PrefixHistogram
+}
+
+// AggregateWithStats is the same as AggregateWithOptions, and also returns
+// the stats of the run. Declined merges are not counted, and the stats are
+// left empty when it fails.
+func AggregateWithStats(cidrEntries []CidrEntry, mergeFn Merge, opts Options) ([]CidrEntry, Stats, error) {
+	stats := Stats{
+		InputCount:  len(cidrEntries),
+		AddressesV4: new(big.Int),
+		AddressesV6: new(big.Int),
+	}
+	for _, cidrEntry := range cidrEntries {
+		stats.Before.add(cidrEntry.GetNetwork())
+	}
+
+	// count through a MergeIf calling whatever merge func opts would pick
+	mergeIf, mergeWithInfo := opts.MergeIf, opts.MergeWithInfo
+	opts.MergeIf = func(keep, delete CidrEntry, info MergeInfo) bool {
+		switch {
+		case mergeIf != nil:
+			if !mergeIf(keep, delete, info) {
+				return false
+			}
+		case mergeWithInfo != nil:
+			mergeWithInfo(keep, delete, info)
+		case mergeFn != nil:
+			mergeFn(keep, delete)
+		}
+		if info.Kind == MergeAdjacent {
+			stats.SiblingMerges++
+		} else {
+			stats.Covered++
+		}
+		return true
+	}
+
+	r, err := AggregateWithOptions(cidrEntries, mergeFn, opts)
+	if err != nil {
+		return nil, Stats{}, err
+	}
+
+	stats.OutputCount = len(r)
+	prefixes := make([]netip.Prefix, 0, len(r))
+	for _, cidrEntry := range r {
+		prefix := cidrEntry.GetNetwork()
+		stats.After.add(prefix)
+		if prefix.IsValid() {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	// the result overlaps with Key or a declined merge, count each address
+	// only once
+	for _, prefix := range AggregatePrefixes(prefixes) {
+		size := new(big.Int).Lsh(big.NewInt(1), uint(prefix.Addr().BitLen()-prefix.Bits()))
+		if prefix.Addr().Is4() {
+			stats.AddressesV4.Add(stats.AddressesV4, size)
+		} else {
+			stats.AddressesV6.Add(stats.AddressesV6, size)
+		}
+	}
+	return r, stats, nil
+}
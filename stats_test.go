@@ -0,0 +1,137 @@
+package Agg
+
+import (
+	"errors"
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+func TestAggregateWithStats(t *testing.T) {
+	var input = []testResults{
+		{"8.8.8.0/24", 1},
+		{"8.8.8.0/25", 2},
+		{"8.8.8.0/24", 4},
+		{"8.8.9.0/25", 8},
+		{"8.8.9.128/25", 16},
+		{"9.9.9.0/24", 32},
+		{"::/0", 64},
+		{"2001:db8::/32", 128},
+	}
+	var want = []testResults{
+		{"8.8.8.0/23", 31},
+		{"9.9.9.0/24", 32},
+		{"::/0", 192},
+	}
+
+	var cidrEntries []CidrEntry
+	for _, s := range input {
+		cidrEntries = append(cidrEntries, NewCustomCidrEntry(netip.MustParsePrefix(s.ipnetString), s.count, "US"))
+	}
+	var cidrWant []CidrEntry
+	for _, s := range want {
+		cidrWant = append(cidrWant, NewCustomCidrEntry(netip.MustParsePrefix(s.ipnetString), s.count, "US"))
+	}
+
+	got, stats, err := AggregateWithStats(cidrEntries, mergeAddCount, Options{})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if !reflect.DeepEqual(got, cidrWant) {
+		t.Errorf("expect: %+v , but got %+v", cidrWant, got)
+	}
+
+	if stats.InputCount != 8 || stats.OutputCount != 3 {
+		t.Errorf("expect 8 in and 3 out but got %d and %d", stats.InputCount, stats.OutputCount)
+	}
+	// a /25 and a duplicate /24 in v4, the /32 in v6
+	if stats.Covered != 3 {
+		t.Errorf("expect 3 covered but got %d", stats.Covered)
+	}
+	// two /25s, then two /24s
+	if stats.SiblingMerges != 2 {
+		t.Errorf("expect 2 sibling merges but got %d", stats.SiblingMerges)
+	}
+	if got := stats.AddressesV4.String(); got != "768" {
+		t.Errorf("expect 768 v4 addresses but got %s", got)
+	}
+	if got := stats.AddressesV6.String(); got != "340282366920938463463374607431768211456" {
+		t.Errorf("expect 2^128 v6 addresses but got %s", got)
+	}
+
+	var before, after PrefixHistogram
+	before.V4[24], before.V4[25] = 3, 3
+	before.V6[0], before.V6[32] = 1, 1
+	after.V4[23], after.V4[24] = 1, 1
+	after.V6[0] = 1
+	if stats.Before != before {
+		t.Errorf("unexpected histogram before: %+v", stats.Before)
+	}
+	if stats.After != after {
+		t.Errorf("unexpected histogram after: %+v", stats.After)
+	}
+}
+
+func TestAggregateWithStatsDeclined(t *testing.T) {
+	cidrEntries := []CidrEntry{
+		NewCustomCidrEntry(netip.MustParsePrefix("10.0.0.0/24"), 1, "US"),
+		NewCustomCidrEntry(netip.MustParsePrefix("10.0.1.0/24"), 1, "DE"),
+		NewCustomCidrEntry(netip.MustParsePrefix("10.0.0.0/25"), 1, "US"),
+	}
+
+	_, stats, err := AggregateWithStats(cidrEntries, nil, Options{
+		MergeIf: func(keep, delete CidrEntry, _ MergeInfo) bool {
+			return sameNote(keep, delete)
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if stats.OutputCount != 2 || stats.Covered != 1 || stats.SiblingMerges != 0 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestAggregateWithStatsOverlap(t *testing.T) {
+	for i, c := range []struct {
+		in   []notedResults
+		opts Options
+		want string
+	}{
+		// the same /24 twice under different keys
+		{
+			[]notedResults{{"10.0.0.0/24", 1, "US"}, {"10.0.0.0/24", 1, "DE"}},
+			Options{Key: func(c CidrEntry) any { return c.(*customCidrEntry).note }},
+			"256",
+		},
+		// a declined /24 inside a /16
+		{
+			[]notedResults{{"10.0.0.0/16", 1, "US"}, {"10.0.1.0/24", 1, "DE"}},
+			Options{MergeIf: func(keep, delete CidrEntry, _ MergeInfo) bool { return sameNote(keep, delete) }},
+			"65536",
+		},
+	} {
+		cidrEntries := notedEntries(c.in)
+
+		got, stats, err := AggregateWithStats(cidrEntries, nil, c.opts)
+		if err != nil {
+			t.Errorf("#%d: unexpected error %v", i, err)
+			continue
+		}
+		if len(got) != 2 || stats.OutputCount != 2 {
+			t.Errorf("#%d: expect the overlap kept but got %+v", i, got)
+		}
+		if got := stats.AddressesV4.String(); got != c.want {
+			t.Errorf("#%d: expect %s v4 addresses but got %s", i, c.want, got)
+		}
+	}
+}
+
+func TestAggregateWithStatsInvalid(t *testing.T) {
+	cidrEntries := []CidrEntry{NewBasicCidrEntry(netip.MustParsePrefix("10.0.0.0/16"))}
+
+	got, stats, err := AggregateWithStats(cidrEntries, nil, Options{MinPrefixLenV6: 129})
+	if !errors.Is(err, ErrInvalidOptions) || got != nil || stats.InputCount != 0 {
+		t.Errorf("expect ErrInvalidOptions but got %v, %+v, %+v", err, got, stats)
+	}
+}
@@ -0,0 +1,82 @@
+package Agg
+
+import (
+	"net/netip"
+)
+
+// Subtract removes the address space of exclude from base, and returns what
+// is left the same way Aggregate would. The base entries are aggregated with
+// mergeFn first, then a base entry split into several pieces is kept for the
+// first piece and cloned for each of the others, so every piece carries its
+// attributes. The attributes of the exclude entries are not used.
+func Subtract(base, exclude []CidrEntry, clone func(CidrEntry) CidrEntry, mergeFn Merge) []CidrEntry {
+	if len(base) == 0 {
+		return nil
+	}
+	_, head := aggregateToList(base, mergeFn)
+
+	var r []CidrEntry
+	subtractList(head, toDisjointCidrs(exclude), func(c *cidr, i int, piece cidr) {
+		pieceEntry := base[c.idx]
+		if i > 0 {
+			pieceEntry = clone(pieceEntry)
+		}
+		pieceEntry.SetNetwork(piece.prefix())
+		r = append(r, pieceEntry)
+	})
+	return r
+}
+
+// subtractList subtracts the sorted and disjoint excludes from every cidr of
+// the list starting at head, and calls emit for each piece left of it
+func subtractList(head *cidr, excludes []cidr, emit func(c *cidr, i int, piece cidr)) {
+	j := 0
+	for currentP := head; currentP != nil; currentP = currentP.next {
+		// both lists are sorted, skip the excludes before the current one
+		for j < len(excludes) && (excludes[j].bits < currentP.bits ||
+			excludes[j].bits == currentP.bits && excludes[j].endIP.cmp(currentP.startIP) < 0) {
+			j++
+		}
+
+		for i, piece := range subtractCidr(currentP, excludes[j:]) {
+			emit(currentP, i, piece)
+		}
+	}
+}
+
+// toDisjointCidrs aggregates the networks of the cidr entries, and returns
+// them as sorted cidrs without any overlap
+func toDisjointCidrs(cidrEntries []CidrEntry) []cidr {
+	prefixes := make([]netip.Prefix, 0, len(cidrEntries))
+	for _, cidrEntry := range cidrEntries {
+		prefixes = append(prefixes, cidrEntry.GetNetwork())
+	}
+	prefixes = AggregatePrefixes(prefixes)
+
+	cidrs := make([]cidr, 0, len(prefixes))
+	for i, prefix := range prefixes {
+		cidrs = append(cidrs, newCidr(prefix, i))
+	}
+	return cidrs
+}
+
+// subtractCidr returns the minimal cidrs covering c but none of the sorted
+// and disjoint excludes, the first exclude must not end before c
+func subtractCidr(c *cidr, excludes []cidr) []cidr {
+	var r []cidr
+	startIP := c.startIP
+	for _, e := range excludes {
+		if e.bits != c.bits || e.startIP.cmp(c.endIP) > 0 {
+			break
+		}
+		if e.startIP.cmp(startIP) > 0 {
+			r = append(r, rangeToCidrs(startIP, e.startIP.sub(uint128{lo: 1}), c.bits)...)
+		}
+		if e.endIP.cmp(c.endIP) >= 0 {
+			// nothing left
+			return r
+		}
+		startIP = e.endIP.addOne()
+	}
+	return append(r, rangeToCidrs(startIP, c.endIP, c.bits)...)
+}
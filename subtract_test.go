@@ -0,0 +1,118 @@
+package Agg
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+func TestSubtract(t *testing.T) {
+
+	for i, c := range []struct {
+		base    []string
+		exclude []string
+		want    []testResults
+	}{
+		{
+			[]string{"10.0.0.0/22"},
+			[]string{"10.0.1.0/24"},
+			[]testResults{
+				{"10.0.0.0/24", 1},
+				{"10.0.2.0/23", 1},
+			},
+		},
+		// base aggregated first, every piece gets the merged attributes
+		{
+			[]string{"10.0.1.0/24", "10.0.0.0/24"},
+			[]string{"10.0.0.128/25"},
+			[]testResults{
+				{"10.0.0.0/25", 2},
+				{"10.0.1.0/24", 2},
+			},
+		},
+		// exclude bigger than the base, or spanning several of them
+		{
+			[]string{"10.0.0.0/24", "10.0.2.0/24", "10.1.0.0/16", "192.0.2.0/24"},
+			[]string{"10.0.0.0/16", "192.0.2.0/23"},
+			[]testResults{
+				{"10.1.0.0/16", 1},
+			},
+		},
+		// nothing to exclude
+		{
+			[]string{"10.0.0.0/24", "10.0.1.0/24"},
+			[]string{},
+			[]testResults{
+				{"10.0.0.0/23", 2},
+			},
+		},
+		// several excludes within one base
+		{
+			[]string{"10.0.0.0/24"},
+			[]string{"10.0.0.64/26", "10.0.0.0/27", "10.0.0.255/32"},
+			[]testResults{
+				{"10.0.0.32/27", 1},
+				{"10.0.0.128/26", 1},
+				{"10.0.0.192/27", 1},
+				{"10.0.0.224/28", 1},
+				{"10.0.0.240/29", 1},
+				{"10.0.0.248/30", 1},
+				{"10.0.0.252/31", 1},
+				{"10.0.0.254/32", 1},
+			},
+		},
+		// the families never touch each other
+		{
+			[]string{"10.0.0.0/24", "::/96", "2001:db8::/32"},
+			[]string{"10.0.0.0/24", "2001:db8::/34"},
+			[]testResults{
+				{"::/96", 1},
+				{"2001:db8:4000::/34", 1},
+				{"2001:db8:8000::/33", 1},
+			},
+		},
+		// up to the last address
+		{
+			[]string{"::/0", "0.0.0.0/0"},
+			[]string{"::/1", "0.0.0.0/1"},
+			[]testResults{
+				{"128.0.0.0/1", 1},
+				{"8000::/1", 1},
+			},
+		},
+	} {
+		var base []CidrEntry
+		for _, s := range c.base {
+			base = append(base, NewCustomCidrEntry(netip.MustParsePrefix(s), 1, "US"))
+		}
+		var exclude []CidrEntry
+		for _, s := range c.exclude {
+			exclude = append(exclude, NewBasicCidrEntry(netip.MustParsePrefix(s)))
+		}
+
+		var cidrWant []CidrEntry
+		for _, s := range c.want {
+			cidrWant = append(cidrWant, NewCustomCidrEntry(netip.MustParsePrefix(s.ipnetString), s.count, "US"))
+		}
+
+		got := Subtract(base, exclude, cloneCustom, mergeAddCount)
+
+		if !reflect.DeepEqual(got, cidrWant) {
+			t.Errorf("#%d: expect: %+v , but got %+v", i, cidrWant, got)
+		}
+	}
+}
+
+func TestSubtractKeepsFirstEntry(t *testing.T) {
+	base := NewCustomCidrEntry(netip.MustParsePrefix("10.0.0.0/23"), 1, "US")
+	exclude := NewBasicCidrEntry(netip.MustParsePrefix("10.0.0.0/25"))
+
+	got := Subtract([]CidrEntry{base}, []CidrEntry{exclude}, cloneCustom, mergeAddCount)
+
+	if len(got) != 2 {
+		t.Fatalf("expect 2 pieces but got %+v", got)
+	}
+	if got[0] != base || got[1] == base {
+		t.Errorf("expect only the first piece to be the base entry: %+v", got)
+	}
+}
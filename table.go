@@ -0,0 +1,144 @@
+package Agg
+
+import (
+	"net/netip"
+)
+
+// Table is a longest prefix match lookup table of cidr entries, usually built
+// from the result of Aggregate. It is immutable once built, so it is safe for
+// concurrent readers.
+type Table struct {
+	v4 []tableEntry
+	v6 []tableEntry
+}
+
+type tableEntry struct {
+	startIP uint128
+	endIP   uint128
+	ones    int
+	// index of the closest entry covering this one, -1 for none
+	parent int
+
+	entry CidrEntry
+}
+
+// NewTable builds a table from the cidr entries, they can overlap and the
+// most specific one wins on lookup. Entries with an invalid network are left
+// out, and the entries themselves are never written to.
+func NewTable(cidrEntries []CidrEntry) *Table {
+	var valid []CidrEntry
+	for _, cidrEntry := range cidrEntries {
+		if cidrEntry.GetNetwork().IsValid() {
+			valid = append(valid, cidrEntry)
+		}
+	}
+	cidrs := convertToCidr(valid)
+	sortIt(cidrs)
+
+	t := &Table{}
+	var stack []int
+	for i := range cidrs {
+		c := &cidrs[i]
+		entries := &t.v6
+		if c.bits == 32 {
+			entries = &t.v4
+		}
+		if len(*entries) == 0 {
+			// new family
+			stack = stack[:0]
+		}
+
+		// the stack holds the chain of entries covering the previous one,
+		// drop the ones ending before this one
+		for len(stack) > 0 && (*entries)[stack[len(stack)-1]].endIP.cmp(c.startIP) < 0 {
+			stack = stack[:len(stack)-1]
+		}
+		parent := -1
+		if len(stack) > 0 {
+			parent = stack[len(stack)-1]
+		}
+
+		*entries = append(*entries, tableEntry{
+			startIP: c.startIP,
+			endIP:   c.endIP,
+			ones:    c.ones,
+			parent:  parent,
+			entry:   valid[c.idx],
+		})
+		stack = append(stack, len(*entries)-1)
+	}
+	return t
+}
+
+// Len returns the number of entries in the table
+func (t *Table) Len() int {
+	return len(t.v4) + len(t.v6)
+}
+
+// Lookup returns the most specific entry containing the ip, IPv4 addresses
+// only match IPv4 entries and IPv6 addresses only IPv6 entries
+func (t *Table) Lookup(ip netip.Addr) (CidrEntry, bool) {
+	if !ip.IsValid() {
+		return nil, false
+	}
+	entries := t.family(ip)
+	u := uint128FromAddr(ip)
+
+	// the last entry starting at or before ip, anything containing ip is
+	// either this one or one of its parents
+	i := search(entries, func(e *tableEntry) bool {
+		return e.startIP.cmp(u) > 0
+	}) - 1
+	for i >= 0 && entries[i].endIP.cmp(u) < 0 {
+		i = entries[i].parent
+	}
+	if i < 0 {
+		return nil, false
+	}
+	return entries[i].entry, true
+}
+
+// LookupPrefix returns the most specific entry covering the whole prefix
+func (t *Table) LookupPrefix(prefix netip.Prefix) (CidrEntry, bool) {
+	if !prefix.IsValid() {
+		return nil, false
+	}
+	entries := t.family(prefix.Addr())
+	c := newCidr(prefix, 0)
+
+	// the last entry sorted at or before the prefix, anything covering the
+	// prefix is either this one or one of its parents
+	i := search(entries, func(e *tableEntry) bool {
+		startIPCmp := e.startIP.cmp(c.startIP)
+		return startIPCmp > 0 || startIPCmp == 0 && e.ones > c.ones
+	}) - 1
+	for i >= 0 && entries[i].endIP.cmp(c.endIP) < 0 {
+		i = entries[i].parent
+	}
+	if i < 0 {
+		return nil, false
+	}
+	return entries[i].entry, true
+}
+
+func (t *Table) family(ip netip.Addr) []tableEntry {
+	if ip.Is4() {
+		return t.v4
+	}
+	return t.v6
+}
+
+// search returns the first index where after is true, after must be false
+// then true along the entries
+func search(entries []tableEntry, after func(e *tableEntry) bool) int {
+	lo, hi := 0, len(entries)
+	for lo < hi {
+		mid := int(uint(lo+hi) >> 1)
+		if after(&entries[mid]) {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+	return lo
+}
@@ -0,0 +1,170 @@
+package Agg
+
+import (
+	"net/netip"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func newNotedTable(in map[string]string) *Table {
+	var cidrEntries []CidrEntry
+	for s, note := range in {
+		cidrEntries = append(cidrEntries, NewCustomCidrEntry(netip.MustParsePrefix(s), 1, note))
+	}
+	return NewTable(cidrEntries)
+}
+
+func TestTableLookup(t *testing.T) {
+	table := newNotedTable(map[string]string{
+		"10.0.0.0/8":      "a",
+		"10.1.0.0/16":     "b",
+		"10.1.2.0/24":     "c",
+		"10.1.4.0/24":     "d",
+		"192.0.2.0/24":    "e",
+		"0.0.0.0/0":       "f",
+		"::102:300/120":   "g",
+		"2001:db8::/32":   "h",
+		"2001:db8:1::/48": "i",
+	})
+
+	if table.Len() != 9 {
+		t.Errorf("expect 9 entries but got %d", table.Len())
+	}
+
+	for i, c := range []struct {
+		ip   string
+		want string
+	}{
+		{"10.1.2.3", "c"},
+		{"10.1.3.3", "b"},
+		{"10.1.4.255", "d"},
+		{"10.1.5.0", "b"},
+		{"10.2.0.0", "a"},
+		{"10.255.255.255", "a"},
+		{"11.0.0.0", "f"},
+		{"1.2.3.4", "f"},
+		{"192.0.2.255", "e"},
+		{"255.255.255.255", "f"},
+		{"::102:304", "g"},
+		{"2001:db8:1::1", "i"},
+		{"2001:db8:2::1", "h"},
+		{"2001:db9::", ""},
+		{"::1", ""},
+		{"::ffff:10.1.2.3", ""},
+	} {
+		got, ok := table.Lookup(netip.MustParseAddr(c.ip))
+		if c.want == "" {
+			if ok {
+				t.Errorf("#%d: expect no match for %s but got %+v", i, c.ip, got)
+			}
+			continue
+		}
+		sg, _ := got.(*customCidrEntry)
+		if !ok || sg.note != c.want {
+			t.Errorf("#%d: expect %s for %s but got %+v", i, c.want, c.ip, got)
+		}
+	}
+
+	if _, ok := table.Lookup(netip.Addr{}); ok {
+		t.Errorf("expect no match for the zero address")
+	}
+}
+
+func TestTableLookupPrefix(t *testing.T) {
+	table := newNotedTable(map[string]string{
+		"10.0.0.0/8":    "a",
+		"10.1.0.0/16":   "b",
+		"10.1.2.0/24":   "c",
+		"2001:db8::/32": "d",
+	})
+
+	for i, c := range []struct {
+		prefix string
+		want   string
+	}{
+		{"10.1.2.0/24", "c"},
+		{"10.1.2.128/25", "c"},
+		{"10.1.2.0/23", "b"},
+		{"10.1.0.0/16", "b"},
+		{"10.0.0.0/15", "a"},
+		{"10.0.0.0/7", ""},
+		{"11.0.0.0/24", ""},
+		{"2001:db8:1::/48", "d"},
+		{"2001:db8::/31", ""},
+	} {
+		got, ok := table.LookupPrefix(netip.MustParsePrefix(c.prefix))
+		if c.want == "" {
+			if ok {
+				t.Errorf("#%d: expect no match for %s but got %+v", i, c.prefix, got)
+			}
+			continue
+		}
+		sg, _ := got.(*customCidrEntry)
+		if !ok || sg.note != c.want {
+			t.Errorf("#%d: expect %s for %s but got %+v", i, c.want, c.prefix, got)
+		}
+	}
+}
+
+func TestTableLookupNoAlloc(t *testing.T) {
+	table := newNotedTable(map[string]string{
+		"10.0.0.0/8":    "a",
+		"10.1.2.0/24":   "b",
+		"2001:db8::/32": "c",
+	})
+	ip := netip.MustParseAddr("10.1.2.3")
+	prefix := netip.MustParsePrefix("10.1.2.0/25")
+
+	allocs := testing.AllocsPerRun(100, func() {
+		_, _ = table.Lookup(ip)
+		_, _ = table.LookupPrefix(prefix)
+	})
+	if allocs != 0 {
+		t.Errorf("expect no allocation but got %v", allocs)
+	}
+}
+
+func TestTableConcurrentLookup(t *testing.T) {
+	var cidrEntries []CidrEntry
+	for c := 0; c < 256; c++ {
+		ipnet := netip.MustParsePrefix("10.1." + strconv.Itoa(c) + ".0/24")
+		cidrEntries = append(cidrEntries, NewCustomCidrEntry(ipnet, c, "US"))
+	}
+	table := NewTable(cidrEntries)
+
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c := 0; c < 256; c++ {
+				got, ok := table.Lookup(netip.MustParseAddr("10.1." + strconv.Itoa(c) + ".1"))
+				sg, _ := got.(*customCidrEntry)
+				if !ok || sg.count != c {
+					t.Errorf("expect count %d but got %+v", c, got)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func BenchmarkTableLookup(b *testing.B) {
+	var cidrEntries []CidrEntry
+	for c := 0; c < 256; c++ {
+		// every other /24, so nothing gets aggregated
+		for d := 0; d < 256; d += 2 {
+			ipnet := netip.MustParsePrefix("1." + strconv.Itoa(c) + "." + strconv.Itoa(d) + ".0/24")
+			cidrEntries = append(cidrEntries, NewBasicCidrEntry(ipnet))
+		}
+	}
+	table := NewTable(Aggregate(cidrEntries, mergeDoNothing))
+	ip := netip.MustParseAddr("1.128.6.1")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = table.Lookup(ip)
+	}
+}
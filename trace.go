@@ -0,0 +1,120 @@
+package Agg
+
+import (
+	"context"
+	"log/slog"
+	"net/netip"
+)
+
+// TraceKind tells what step of the aggregation a TraceEvent is about
+type TraceKind int
+
+const (
+	// TraceSorted is sent for every entry in sorted order before anything
+	// is merged
+	TraceSorted TraceKind = iota
+	// TraceCovered is sent when Other is covered by, or the same as, Prefix
+	// and removed from the list
+	TraceCovered
+	// TraceSibling is sent when the siblings Prefix and Other are combined
+	// into their parent Result
+	TraceSibling
+	// TraceBacktrack is sent when the walk moves back from the merged Other
+	// to the previous Prefix, to try it with the new parent
+	TraceBacktrack
+	// TraceEmit is sent for every entry of the result in order
+	TraceEmit
+	// TraceHeld is sent when Other, covered by Prefix, is moved aside to be
+	// aggregated with the other held entries only. That is a declined covered
+	// entry, or one inside a declined covered entry
+	TraceHeld
+	// TraceRejoin is sent for every held entry left once they are aggregated,
+	// when they go back into the list they were held from. An entry held
+	// inside a held entry rejoins once for each level
+	TraceRejoin
+)
+
+func (k TraceKind) String() string {
+	switch k {
+	case TraceSorted:
+		return "sorted"
+	case TraceCovered:
+		return "covered"
+	case TraceSibling:
+		return "sibling"
+	case TraceBacktrack:
+		return "backtrack"
+	case TraceEmit:
+		return "emit"
+	case TraceHeld:
+		return "held"
+	case TraceRejoin:
+		return "rejoin"
+	}
+	return "unknown"
+}
+
+// TraceEvent is a single step of the aggregation
+type TraceEvent struct {
+	Kind TraceKind
+	// Index and Prefix are the sorted or emitted entry, keep of a merge, or
+	// the entry the walk moves back to. Index is of the input slice
+	Index  int
+	Prefix netip.Prefix
+	// OtherIndex and Other are delete of a merge, or the entry the walk
+	// moves back from
+	OtherIndex int
+	Other      netip.Prefix
+	// Result is the network keep has after a merge
+	Result netip.Prefix
+	// Declined is set when the merge func declined the merge, a declined
+	// covered entry is then held, see TraceHeld
+	Declined bool
+}
+
+// Observer watches an aggregation step by step, the events come in the order
+// the steps are done and are enough to replay the run
+type Observer interface {
+	Observe(TraceEvent)
+}
+
+type slogObserver struct {
+	logger *slog.Logger
+	level  slog.Level
+}
+
+// NewSlogObserver returns an Observer writing every event to logger at the
+// given level
+func NewSlogObserver(logger *slog.Logger, level slog.Level) Observer {
+	return &slogObserver{
+		logger: logger,
+		level:  level,
+	}
+}
+
+func (o *slogObserver) Observe(e TraceEvent) {
+	ctx := context.Background()
+	if !o.logger.Enabled(ctx, o.level) {
+		return
+	}
+	attrs := []slog.Attr{
+		slog.String("step", e.Kind.String()),
+		slog.Int("index", e.Index),
+		slog.String("prefix", e.Prefix.String()),
+	}
+	switch e.Kind {
+	case TraceCovered, TraceSibling:
+		attrs = append(attrs,
+			slog.Int("other_index", e.OtherIndex),
+			slog.String("other", e.Other.String()),
+			slog.String("result", e.Result.String()),
+			slog.Bool("declined", e.Declined),
+		)
+	case TraceBacktrack, TraceHeld:
+		attrs = append(attrs,
+			slog.Int("other_index", e.OtherIndex),
+			slog.String("other", e.Other.String()),
+		)
+	}
+	o.logger.LogAttrs(ctx, o.level, "aggregate", attrs...)
+}
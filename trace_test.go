@@ -0,0 +1,165 @@
+package Agg
+
+import (
+	"bytes"
+	"log/slog"
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type recordObserver struct {
+	events []TraceEvent
+}
+
+func (r *recordObserver) Observe(e TraceEvent) {
+	r.events = append(r.events, e)
+}
+
+func TestAggregateObserver(t *testing.T) {
+	var cidrEntries []CidrEntry
+	for _, s := range []string{"10.0.0.0/24", "10.0.1.0/25", "10.0.1.128/25", "10.0.0.0/26"} {
+		cidrEntries = append(cidrEntries, NewCustomCidrEntry(netip.MustParsePrefix(s), 1, "US"))
+	}
+
+	p := netip.MustParsePrefix
+	want := []TraceEvent{
+		{Kind: TraceSorted, Index: 0, Prefix: p("10.0.0.0/24")},
+		{Kind: TraceSorted, Index: 3, Prefix: p("10.0.0.0/26")},
+		{Kind: TraceSorted, Index: 1, Prefix: p("10.0.1.0/25")},
+		{Kind: TraceSorted, Index: 2, Prefix: p("10.0.1.128/25")},
+		{Kind: TraceCovered, Index: 0, Prefix: p("10.0.0.0/24"), OtherIndex: 3, Other: p("10.0.0.0/26"), Result: p("10.0.0.0/24")},
+		{Kind: TraceSibling, Index: 1, Prefix: p("10.0.1.0/25"), OtherIndex: 2, Other: p("10.0.1.128/25"), Result: p("10.0.1.0/24")},
+		{Kind: TraceBacktrack, Index: 0, Prefix: p("10.0.0.0/24"), OtherIndex: 1, Other: p("10.0.1.0/24")},
+		{Kind: TraceSibling, Index: 0, Prefix: p("10.0.0.0/24"), OtherIndex: 1, Other: p("10.0.1.0/24"), Result: p("10.0.0.0/23")},
+		{Kind: TraceEmit, Index: 0, Prefix: p("10.0.0.0/23")},
+	}
+
+	observer := &recordObserver{}
+	got, err := AggregateWithOptions(cidrEntries, mergeAddCount, Options{Observer: observer})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	cidrWant := []CidrEntry{NewCustomCidrEntry(p("10.0.0.0/23"), 4, "US")}
+	if !reflect.DeepEqual(got, cidrWant) {
+		t.Errorf("expect: %+v , but got %+v", cidrWant, got)
+	}
+	if !reflect.DeepEqual(observer.events, want) {
+		t.Errorf("expect events: %+v , but got %+v", want, observer.events)
+	}
+}
+
+func TestAggregateObserverDeclined(t *testing.T) {
+	cidrEntries := []CidrEntry{
+		NewCustomCidrEntry(netip.MustParsePrefix("10.0.0.0/25"), 1, "US"),
+		NewCustomCidrEntry(netip.MustParsePrefix("10.0.0.128/25"), 1, "DE"),
+	}
+
+	observer := &recordObserver{}
+	_, err := AggregateWithOptions(cidrEntries, nil, Options{
+		MergeIf: func(keep, delete CidrEntry, _ MergeInfo) bool {
+			return sameNote(keep, delete)
+		},
+		Observer: observer,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	var kinds []TraceKind
+	for _, e := range observer.events {
+		kinds = append(kinds, e.Kind)
+		if e.Kind == TraceSibling && !e.Declined {
+			t.Errorf("expect the sibling merge declined: %+v", e)
+		}
+	}
+	want := []TraceKind{TraceSorted, TraceSorted, TraceSibling, TraceEmit, TraceEmit}
+	if !reflect.DeepEqual(kinds, want) {
+		t.Errorf("expect: %v , but got %v", want, kinds)
+	}
+}
+
+func TestAggregateObserverHeld(t *testing.T) {
+	var cidrEntries []CidrEntry
+	for _, s := range [][2]string{
+		{"10.0.0.0/16", "US"}, {"10.0.1.0/24", "DE"}, {"10.0.1.0/25", "US"},
+		{"10.0.1.128/26", "DE"}, {"10.0.2.0/24", "US"},
+	} {
+		cidrEntries = append(cidrEntries, NewCustomCidrEntry(netip.MustParsePrefix(s[0]), 1, s[1]))
+	}
+
+	p := netip.MustParsePrefix
+	want := []TraceEvent{
+		{Kind: TraceSorted, Index: 0, Prefix: p("10.0.0.0/16")},
+		{Kind: TraceSorted, Index: 1, Prefix: p("10.0.1.0/24")},
+		{Kind: TraceSorted, Index: 2, Prefix: p("10.0.1.0/25")},
+		{Kind: TraceSorted, Index: 3, Prefix: p("10.0.1.128/26")},
+		{Kind: TraceSorted, Index: 4, Prefix: p("10.0.2.0/24")},
+		{Kind: TraceCovered, Index: 0, Prefix: p("10.0.0.0/16"), OtherIndex: 1, Other: p("10.0.1.0/24"), Result: p("10.0.0.0/16"), Declined: true},
+		{Kind: TraceHeld, Index: 0, Prefix: p("10.0.0.0/16"), OtherIndex: 1, Other: p("10.0.1.0/24")},
+		// inside the held one, moved aside without asking the merge func
+		{Kind: TraceHeld, Index: 1, Prefix: p("10.0.1.0/24"), OtherIndex: 2, Other: p("10.0.1.0/25")},
+		{Kind: TraceHeld, Index: 1, Prefix: p("10.0.1.0/24"), OtherIndex: 3, Other: p("10.0.1.128/26")},
+		{Kind: TraceCovered, Index: 0, Prefix: p("10.0.0.0/16"), OtherIndex: 4, Other: p("10.0.2.0/24"), Result: p("10.0.0.0/16")},
+		// the held list on its own
+		{Kind: TraceCovered, Index: 1, Prefix: p("10.0.1.0/24"), OtherIndex: 2, Other: p("10.0.1.0/25"), Result: p("10.0.1.0/24"), Declined: true},
+		{Kind: TraceHeld, Index: 1, Prefix: p("10.0.1.0/24"), OtherIndex: 2, Other: p("10.0.1.0/25")},
+		{Kind: TraceCovered, Index: 1, Prefix: p("10.0.1.0/24"), OtherIndex: 3, Other: p("10.0.1.128/26"), Result: p("10.0.1.0/24")},
+		{Kind: TraceRejoin, Index: 2, Prefix: p("10.0.1.0/25")},
+		{Kind: TraceRejoin, Index: 1, Prefix: p("10.0.1.0/24")},
+		{Kind: TraceRejoin, Index: 2, Prefix: p("10.0.1.0/25")},
+		{Kind: TraceEmit, Index: 0, Prefix: p("10.0.0.0/16")},
+		{Kind: TraceEmit, Index: 1, Prefix: p("10.0.1.0/24")},
+		{Kind: TraceEmit, Index: 2, Prefix: p("10.0.1.0/25")},
+	}
+
+	observer := &recordObserver{}
+	_, err := AggregateWithOptions(cidrEntries, nil, Options{
+		MergeIf: func(keep, delete CidrEntry, _ MergeInfo) bool {
+			return sameNote(keep, delete)
+		},
+		Observer: observer,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if !reflect.DeepEqual(observer.events, want) {
+		t.Errorf("expect events: %+v , but got %+v", want, observer.events)
+	}
+}
+
+func TestSlogObserver(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+
+	cidrEntries := []CidrEntry{
+		NewBasicCidrEntry(netip.MustParsePrefix("10.0.0.0/25")),
+		NewBasicCidrEntry(netip.MustParsePrefix("10.0.0.128/25")),
+	}
+	if _, err := AggregateWithOptions(cidrEntries, nil, Options{Observer: NewSlogObserver(logger, slog.LevelInfo)}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	out := buf.String()
+	for _, s := range []string{
+		"step=sorted index=0 prefix=10.0.0.0/25",
+		"step=sibling index=0 prefix=10.0.0.0/25 other_index=1 other=10.0.0.128/25 result=10.0.0.0/24 declined=false",
+		"step=emit index=0 prefix=10.0.0.0/24",
+	} {
+		if !strings.Contains(out, s) {
+			t.Errorf("expect %q in the log but got %s", s, out)
+		}
+	}
+
+	// below the level nothing is written
+	buf.Reset()
+	if _, err := AggregateWithOptions(cidrEntries, nil, Options{Observer: NewSlogObserver(logger, slog.LevelDebug)}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("expect no log but got %s", buf.String())
+	}
+}
@@ -0,0 +1,116 @@
+package Agg
+
+import (
+	"encoding/binary"
+	"math/big"
+	"math/bits"
+	"net/netip"
+)
+
+// uint128 is a fixed width unsigned integer wide enough for both IPv4 and
+// IPv6 addresses, it is a value type so no allocation is needed
+type uint128 struct {
+	hi uint64
+	lo uint64
+}
+
+func uint128FromAddr(ip netip.Addr) uint128 {
+	if ip.Is4() {
+		b := ip.As4()
+		return uint128{lo: uint64(binary.BigEndian.Uint32(b[:]))}
+	}
+	b := ip.As16()
+	return uint128{
+		hi: binary.BigEndian.Uint64(b[:8]),
+		lo: binary.BigEndian.Uint64(b[8:]),
+	}
+}
+
+// addr converts u back to an address of the family with the given bit length
+func (u uint128) addr(bits int) netip.Addr {
+	if bits == 32 {
+		var b [4]byte
+		binary.BigEndian.PutUint32(b[:], uint32(u.lo))
+		return netip.AddrFrom4(b)
+	}
+	var b [16]byte
+	binary.BigEndian.PutUint64(b[:8], u.hi)
+	binary.BigEndian.PutUint64(b[8:], u.lo)
+	return netip.AddrFrom16(b)
+}
+
+// hostMask returns a value with the lowest n bits set
+func hostMask(n int) uint128 {
+	switch {
+	case n <= 0:
+		return uint128{}
+	case n < 64:
+		return uint128{lo: 1<<uint(n) - 1}
+	case n < 128:
+		return uint128{hi: 1<<uint(n-64) - 1, lo: ^uint64(0)}
+	default:
+		return uint128{hi: ^uint64(0), lo: ^uint64(0)}
+	}
+}
+
+func (u uint128) cmp(v uint128) int {
+	switch {
+	case u.hi < v.hi:
+		return -1
+	case u.hi > v.hi:
+		return 1
+	case u.lo < v.lo:
+		return -1
+	case u.lo > v.lo:
+		return 1
+	}
+	return 0
+}
+
+func (u uint128) or(v uint128) uint128 {
+	return uint128{hi: u.hi | v.hi, lo: u.lo | v.lo}
+}
+
+// addOne returns u + 1, wrapping around to zero on overflow
+func (u uint128) addOne() uint128 {
+	lo, carry := bits.Add64(u.lo, 1, 0)
+	return uint128{hi: u.hi + carry, lo: lo}
+}
+
+// add returns u + v, wrapping around on overflow
+func (u uint128) add(v uint128) uint128 {
+	lo, carry := bits.Add64(u.lo, v.lo, 0)
+	hi, _ := bits.Add64(u.hi, v.hi, carry)
+	return uint128{hi: hi, lo: lo}
+}
+
+// sub returns u - v, wrapping around on underflow
+func (u uint128) sub(v uint128) uint128 {
+	lo, borrow := bits.Sub64(u.lo, v.lo, 0)
+	hi, _ := bits.Sub64(u.hi, v.hi, borrow)
+	return uint128{hi: hi, lo: lo}
+}
+
+func (u uint128) xor(v uint128) uint128 {
+	return uint128{hi: u.hi ^ v.hi, lo: u.lo ^ v.lo}
+}
+
+func (u uint128) leadingZeros() int {
+	if u.hi != 0 {
+		return bits.LeadingZeros64(u.hi)
+	}
+	return 64 + bits.LeadingZeros64(u.lo)
+}
+
+func (u uint128) big() *big.Int {
+	b := new(big.Int).SetUint64(u.hi)
+	b.Lsh(b, 64)
+	return b.Or(b, new(big.Int).SetUint64(u.lo))
+}
+
+func (u uint128) trailingZeros() int {
+	if u.lo != 0 {
+		return bits.TrailingZeros64(u.lo)
+	}
+	return 64 + bits.TrailingZeros64(u.hi)
+}
@@ -0,0 +1,99 @@
+package Agg
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestUint128FromAddr(t *testing.T) {
+	for i, c := range []struct {
+		in   string
+		want uint128
+	}{
+		{"0.0.0.0", uint128{}},
+		{"8.8.8.8", uint128{lo: 0x08080808}},
+		{"255.255.255.255", uint128{lo: 0xffffffff}},
+		{"::", uint128{}},
+		{"2001:db8::1", uint128{hi: 0x20010db800000000, lo: 1}},
+		{"ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff", uint128{hi: ^uint64(0), lo: ^uint64(0)}},
+	} {
+		got := uint128FromAddr(netip.MustParseAddr(c.in))
+		if got != c.want {
+			t.Errorf("#%d: expect %+v but got %+v", i, c.want, got)
+		}
+	}
+}
+
+func TestUint128HostMask(t *testing.T) {
+	for i, c := range []struct {
+		n    int
+		want uint128
+	}{
+		{0, uint128{}},
+		{8, uint128{lo: 0xff}},
+		{64, uint128{lo: ^uint64(0)}},
+		{72, uint128{hi: 0xff, lo: ^uint64(0)}},
+		{128, uint128{hi: ^uint64(0), lo: ^uint64(0)}},
+	} {
+		got := hostMask(c.n)
+		if got != c.want {
+			t.Errorf("#%d: expect %+v but got %+v", i, c.want, got)
+		}
+	}
+}
+
+func TestUint128AddOneAndCmp(t *testing.T) {
+	a := uint128{lo: ^uint64(0)}
+	b := a.addOne()
+	if b != (uint128{hi: 1}) {
+		t.Errorf("expect carry into hi but got %+v", b)
+	}
+	if a.cmp(b) != -1 || b.cmp(a) != 1 || a.cmp(a) != 0 {
+		t.Errorf("unexpected cmp result between %+v and %+v", a, b)
+	}
+
+	max := uint128{hi: ^uint64(0), lo: ^uint64(0)}
+	if got := max.addOne(); got != (uint128{}) {
+		t.Errorf("expect wrap around to zero but got %+v", got)
+	}
+}
+
+func TestUint128Addr(t *testing.T) {
+	for i, s := range []string{
+		"0.0.0.0", "8.8.8.8", "255.255.255.255",
+		"::", "::102:304", "2001:db8::1", "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff",
+	} {
+		ip := netip.MustParseAddr(s)
+		got := uint128FromAddr(ip).addr(ip.BitLen())
+		if got != ip {
+			t.Errorf("#%d: expect %s but got %s", i, ip, got)
+		}
+	}
+}
+
+func TestUint128Arithmetic(t *testing.T) {
+	a := uint128{hi: 1, lo: 0}
+	b := uint128{lo: 1}
+
+	if got := a.sub(b); got != (uint128{lo: ^uint64(0)}) {
+		t.Errorf("expect borrow from hi but got %+v", got)
+	}
+	if got := a.sub(b).add(b); got != a {
+		t.Errorf("expect %+v but got %+v", a, got)
+	}
+	if got := a.xor(b).leadingZeros(); got != 63 {
+		t.Errorf("expect 63 leading zeros but got %d", got)
+	}
+	if got := b.leadingZeros(); got != 127 {
+		t.Errorf("expect 127 leading zeros but got %d", got)
+	}
+	if got := a.trailingZeros(); got != 64 {
+		t.Errorf("expect 64 trailing zeros but got %d", got)
+	}
+	if got := (uint128{}).trailingZeros(); got != 128 {
+		t.Errorf("expect 128 trailing zeros but got %d", got)
+	}
+	if got := a.or(b).big().String(); got != "18446744073709551617" {
+		t.Errorf("expect 2^64+1 but got %s", got)
+	}
+}
@@ -0,0 +1,132 @@
+package Agg
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+var (
+	// ErrHostBits is reported for an entry with bits set past its prefix
+	// length, e.g. 10.0.0.5/24
+	ErrHostBits = errors.New("host bits set")
+	// ErrInvalidPrefix is reported for an entry with an invalid prefix, e.g.
+	// the zero netip.Prefix
+	ErrInvalidPrefix = errors.New("invalid prefix")
+)
+
+// Strictness tells AggregateE what to do with entries Aggregate would
+// silently fix up
+type Strictness int
+
+const (
+	// RejectHostBits fails on any entry with host bits set or an invalid
+	// prefix
+	RejectHostBits Strictness = iota
+	// RejectInvalid fails on an invalid prefix only, host bits are masked off
+	// the same way Aggregate does
+	RejectInvalid
+	// NormalizeAndReport never fails, host bits are masked off and entries
+	// with an invalid prefix are left out. The aggregation goes ahead and
+	// every such entry is reported in the returned error
+	NormalizeAndReport
+)
+
+// EntryError is a problem found with a single entry
+type EntryError struct {
+	// Index of the entry in the input
+	Index int
+	Entry CidrEntry
+	// Prefix is the network of the entry as it was checked, the entry itself
+	// may be fixed up by the aggregation afterwards
+	Prefix netip.Prefix
+	// Err is ErrHostBits, ErrInvalidPrefix or ErrMappedAddr
+	Err error
+}
+
+func (e *EntryError) Error() string {
+	return fmt.Sprintf("entry %d %s: %v", e.Index, e.Prefix, e.Err)
+}
+
+func (e *EntryError) Unwrap() error {
+	return e.Err
+}
+
+// ValidationError lists every offending entry in input order, errors.Is
+// matches ErrHostBits and ErrInvalidPrefix through it
+type ValidationError struct {
+	Entries []EntryError
+}
+
+func (e *ValidationError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d invalid entries", len(e.Entries))
+	for i := range e.Entries {
+		b.WriteString("; ")
+		b.WriteString(e.Entries[i].Error())
+	}
+	return b.String()
+}
+
+func (e *ValidationError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Entries))
+	for i := range e.Entries {
+		errs = append(errs, &e.Entries[i])
+	}
+	return errs
+}
+
+// AggregateE is the same as AggregateWithOptions but checks every entry
+// first. What happens to an offending entry depends on strictness, an
+// IPv4-mapped IPv6 entry under MappedReject offends the same way an invalid
+// prefix does. When the strictness rejects, nothing is aggregated and the
+// result is nil.
+//
+// For offending entries the error is a *ValidationError, with
+// NormalizeAndReport it comes together with the result. Otherwise the errors
+// are the same as for AggregateWithOptions.
+func AggregateE(cidrEntries []CidrEntry, mergeFn Merge, opts Options, strictness Strictness) ([]CidrEntry, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	var verr ValidationError
+	rejected := false
+	// left out of the aggregation, the indexes of the rest stay the same
+	skip := make([]bool, len(cidrEntries))
+	for i, cidrEntry := range cidrEntries {
+		prefix := cidrEntry.GetNetwork()
+		if _, ok := opts.Mapped.apply(prefix); !ok {
+			verr.Entries = append(verr.Entries, EntryError{Index: i, Entry: cidrEntry, Prefix: prefix, Err: ErrMappedAddr})
+			rejected = rejected || strictness != NormalizeAndReport
+			skip[i] = true
+			continue
+		}
+		switch {
+		case !prefix.IsValid():
+			verr.Entries = append(verr.Entries, EntryError{Index: i, Entry: cidrEntry, Prefix: prefix, Err: ErrInvalidPrefix})
+			rejected = rejected || strictness != NormalizeAndReport
+			skip[i] = true
+			continue
+		case prefix != prefix.Masked():
+			if strictness == RejectInvalid {
+				break
+			}
+			verr.Entries = append(verr.Entries, EntryError{Index: i, Entry: cidrEntry, Prefix: prefix, Err: ErrHostBits})
+			rejected = rejected || strictness == RejectHostBits
+		}
+	}
+
+	if rejected {
+		return nil, &verr
+	}
+	r, _, err := aggregateWithOptions(cidrEntries, skip, mergeFn, opts, false)
+	if err != nil {
+		return nil, err
+	}
+	if len(verr.Entries) > 0 {
+		return r, &verr
+	}
+	return r, nil
+}
@@ -0,0 +1,152 @@
+package Agg
+
+import (
+	"errors"
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+func TestAggregateE(t *testing.T) {
+	type entryErr struct {
+		index int
+		err   error
+	}
+
+	for i, c := range []struct {
+		in         []string
+		strictness Strictness
+		want       []testResults
+		wantErrs   []entryErr
+	}{
+		{
+			[]string{"10.0.0.0/24", "10.0.1.0/24"},
+			RejectHostBits,
+			[]testResults{{"10.0.0.0/23", 2}},
+			nil,
+		},
+		{
+			[]string{"10.0.0.0/24", "10.0.1.5/24", "", "10.0.2.1/32"},
+			RejectHostBits,
+			nil,
+			[]entryErr{{1, ErrHostBits}, {2, ErrInvalidPrefix}},
+		},
+		{
+			[]string{"10.0.0.0/24", "10.0.1.5/24"},
+			RejectInvalid,
+			[]testResults{{"10.0.0.0/23", 2}},
+			nil,
+		},
+		{
+			[]string{"10.0.0.0/24", "10.0.1.5/24", ""},
+			RejectInvalid,
+			nil,
+			[]entryErr{{2, ErrInvalidPrefix}},
+		},
+		{
+			[]string{"", "10.0.0.0/24", "10.0.1.5/24"},
+			NormalizeAndReport,
+			[]testResults{{"10.0.0.0/23", 2}},
+			[]entryErr{{0, ErrInvalidPrefix}, {2, ErrHostBits}},
+		},
+		// a single entry left is normalized as well
+		{
+			[]string{"", "10.0.1.5/24"},
+			NormalizeAndReport,
+			[]testResults{{"10.0.1.0/24", 1}},
+			[]entryErr{{0, ErrInvalidPrefix}, {1, ErrHostBits}},
+		},
+	} {
+		var cidrEntries []CidrEntry
+		for _, s := range c.in {
+			var prefix netip.Prefix
+			if s != "" {
+				prefix = netip.MustParsePrefix(s)
+			}
+			cidrEntries = append(cidrEntries, NewCustomCidrEntry(prefix, 1, "US"))
+		}
+
+		var want []CidrEntry
+		for _, r := range c.want {
+			want = append(want, NewCustomCidrEntry(netip.MustParsePrefix(r.ipnetString), r.count, "US"))
+		}
+
+		got, err := AggregateE(cidrEntries, mergeAddCount, Options{}, c.strictness)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("#%d: expect: %+v , but got %+v", i, want, got)
+		}
+
+		if c.wantErrs == nil {
+			if err != nil {
+				t.Errorf("#%d: unexpected error %v", i, err)
+			}
+			continue
+		}
+		var verr *ValidationError
+		if !errors.As(err, &verr) {
+			t.Errorf("#%d: expect a ValidationError but got %v", i, err)
+			continue
+		}
+		var gotErrs []entryErr
+		for _, e := range verr.Entries {
+			if e.Entry != cidrEntries[e.Index] {
+				t.Errorf("#%d: entry does not match its index: %+v", i, e)
+			}
+			gotErrs = append(gotErrs, entryErr{e.Index, e.Err})
+		}
+		if !reflect.DeepEqual(gotErrs, c.wantErrs) {
+			t.Errorf("#%d: expect errors %+v but got %+v", i, c.wantErrs, gotErrs)
+		}
+		for _, e := range c.wantErrs {
+			if !errors.Is(err, e.err) {
+				t.Errorf("#%d: expect errors.Is %v to match", i, e.err)
+			}
+		}
+	}
+}
+
+func TestAggregateEObserver(t *testing.T) {
+	// the left out entry does not shift the indexes of the rest
+	cidrEntries := []CidrEntry{
+		NewCustomCidrEntry(netip.Prefix{}, 1, "US"),
+		NewCustomCidrEntry(netip.MustParsePrefix("10.0.0.0/25"), 1, "US"),
+		NewCustomCidrEntry(netip.MustParsePrefix("10.0.0.128/25"), 1, "US"),
+	}
+
+	p := netip.MustParsePrefix
+	want := []TraceEvent{
+		{Kind: TraceSorted, Index: 1, Prefix: p("10.0.0.0/25")},
+		{Kind: TraceSorted, Index: 2, Prefix: p("10.0.0.128/25")},
+		{Kind: TraceSibling, Index: 1, Prefix: p("10.0.0.0/25"), OtherIndex: 2, Other: p("10.0.0.128/25"), Result: p("10.0.0.0/24")},
+		{Kind: TraceEmit, Index: 1, Prefix: p("10.0.0.0/24")},
+	}
+
+	observer := &recordObserver{}
+	got, err := AggregateE(cidrEntries, mergeAddCount, Options{Observer: observer}, NormalizeAndReport)
+	if !errors.Is(err, ErrInvalidPrefix) || len(got) != 1 {
+		t.Errorf("expect one entry and ErrInvalidPrefix but got %v, %+v", err, got)
+	}
+	if !reflect.DeepEqual(observer.events, want) {
+		t.Errorf("expect events: %+v , but got %+v", want, observer.events)
+	}
+}
+
+func TestAggregateEReportsInputPrefix(t *testing.T) {
+	// the entry is masked by the aggregation, the error keeps what came in
+	cidrEntries := []CidrEntry{
+		NewCustomCidrEntry(netip.MustParsePrefix("10.0.1.0/24"), 1, "US"),
+		NewCustomCidrEntry(netip.MustParsePrefix("10.0.0.5/24"), 1, "US"),
+	}
+
+	_, err := AggregateE(cidrEntries, mergeAddCount, Options{}, NormalizeAndReport)
+	var verr *ValidationError
+	if !errors.As(err, &verr) || len(verr.Entries) != 1 {
+		t.Fatalf("expect a ValidationError with one entry but got %v", err)
+	}
+	if want := netip.MustParsePrefix("10.0.0.5/24"); verr.Entries[0].Prefix != want {
+		t.Errorf("expect prefix %s but got %s", want, verr.Entries[0].Prefix)
+	}
+	if want := "1 invalid entries; entry 1 10.0.0.5/24: host bits set"; err.Error() != want {
+		t.Errorf("expect %q but got %q", want, err.Error())
+	}
+}